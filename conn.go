@@ -3,9 +3,12 @@ package pgx
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -42,13 +45,147 @@ type ConnConfig struct {
 	// functionality can be controlled on a per query basis by passing a QueryExecMode as the first query argument.
 	DefaultQueryExecMode QueryExecMode
 
+	// BeforeControlQuery is called before the load balancer queries the servers of the cluster on its control
+	// connection. The returned context is used for the query, which allows to set a timeout or attach a trace to it.
+	BeforeControlQuery func(ctx context.Context, conn *Conn) context.Context
+
+	// ClassifyNodeType maps the node_type reported by yb_servers() to the class of the server. If nil, "primary" servers
+	// are primaries and all others are read replicas.
+	ClassifyNodeType func(nodeType string) NodeClass
+
+	// OnTopologyKeysStrictFallback is called when a connect fails with ErrFallbackToOriginalBehaviour, i.e. no server
+	// matching topology_keys is available while fallback_to_topology_keys_only is set.
+	OnTopologyKeysStrictFallback func(clusterName string)
+
+	// OnTopologyChange is called after a refresh of the servers of the cluster found servers added or removed, or
+	// servers whose node type changed, e.g. to warm up a pool when the cluster scales out. It is called on a goroutine
+	// of its own, one call at a time in the order of the refreshes. The refreshes call the OnTopologyChange of the
+	// latest connect to the cluster.
+	OnTopologyChange func(old, new Topology)
+
+	// ShouldRetryConnect is called when a load balanced connect to host fails with err, attempt being the number of
+	// servers tried so far. Another server is tried only if it returns true. If nil, every error is retried up to
+	// load_balance_connect_retries times.
+	ShouldRetryConnect func(host string, attempt int, err error) bool
+
+	// ConnectRetryBackoff returns the time waited before trying another server after a load balanced connect failed,
+	// attempt being the number of servers tried so far. If nil, load_balance_connect_retry_backoff_ms is waited, doubled
+	// after every failed attempt up to load_balance_connect_retry_max_backoff_ms if it is set, see ExponentialBackoff.
+	ConnectRetryBackoff func(attempt int) time.Duration
+
+	// RefreshInterval is the time after which a load balanced connect refreshes the servers of the cluster, counted in
+	// whole seconds, 0 refreshing them on every connect. ParseConfig sets it from yb_servers_refresh_interval. It must
+	// be at most MAX_INTERVAL_SECONDS.
+	RefreshInterval time.Duration
+
+	// ControlConnTimeout bounds the connect of the control connection of the load balancer and its queries. ParseConfig
+	// sets it from yb_control_conn_timeout, in seconds. It must be positive and at most MAX_INTERVAL_SECONDS.
+	ControlConnTimeout time.Duration
+
+	// MaxConnectRetries is the number of other servers a load balanced connect tries after a failed connect to the
+	// selected one. ParseConfig sets it from yb_connect_max_retries or load_balance_connect_retries. It must not be
+	// negative.
+	MaxConnectRetries int
+
+	// LBLogger receives the diagnostics of the load balancer for the connections made with this config. If nil, they
+	// go to the logger set by SetDefaultLBLogger. The refreshes of a cluster log to the LBLogger of the latest connect
+	// to it.
+	LBLogger LBLogger
+
+	// LoadBalancer makes the connections if load_balance is set. If nil, DefaultLoadBalancer is used.
+	LoadBalancer LoadBalancer
+
+	// ClusterManager holds the load information load balanced connections are made with. If nil, the default one
+	// shared by all connections is used.
+	ClusterManager *ClusterManager
+
 	createdByParseConfig bool // Used to enforce created by ParseConfig rule.
 
 	loadBalance                  string
 	topologyKeys                 map[int][]string
-	refreshInterval              int64
 	fallbackToTopologyKeysOnly   bool
 	failedHostReconnectDelaySecs int64
+	// number of recent host selections kept for SelectionDistribution, 0 disables tracking
+	selectionWindow int
+	// seconds during which connections to a host marked away are discarded even if they succeed
+	quarantineSecs int64
+	// connect to the host of the connection string when none of the servers of the cluster could be connected to
+	lastResortOriginalHost bool
+	// fraction by which a tracked connection count above the server's num_connections is lowered on every refresh
+	countDecayFraction float64
+	// database the control connection connects to, the database of the connection string if empty
+	controlDatabase string
+	// name the load information of the cluster is kept under, the address of Host if empty
+	clusterName string
+	// maximum number of load balanced connects to the cluster per second, 0 for no limit
+	connectRate float64
+	// fail connects exceeding connectRate instead of waiting for them to be allowed
+	connectRateFail bool
+	// addresses of the only servers load balanced connections are made to, all servers if nil
+	allowHosts map[string]bool
+	// hosts selected in this order if they are eligible, before the least loaded ones
+	priorityHosts []string
+	// private address of the server the connection count is kept against, Host if empty
+	countedHost string
+	// minimum number of servers to select from, connects fail rather than using fewer
+	minEligibleHosts int
+	// consecutive failures of the load balancer after which connects to the cluster are not load balanced, 0 disables it
+	circuitFailures int
+	// seconds during which connects are not load balanced after circuitFailures failures
+	circuitCooldownSecs int64
+	// connections more than the least loaded server a recently used server may have to be preferred, 0 disables it
+	affinityTolerance int
+	// whether the topology keys of a tier are preferred in the order they are listed rather than balanced across
+	intraTierOrdered bool
+	// whether the servers with the lowest connect latency are preferred over the others, see LATENCY_AWARE_TOLERANCE
+	latencyAware bool
+	// whether the connects hinted with WithShardKey select the server of the leader of the tablet of the key
+	tabletAware bool
+	// number of control connections kept per cluster, each to a different server, the ones beyond the first are
+	// standbys the refresh fails over to
+	controlConns int
+	// replication lag in milliseconds above which read replicas are not selected, 0 disables it
+	maxReplicaLagMs int64
+	// skip the rows of yb_servers() which cannot be read rather than failing the refresh
+	skipBadRows bool
+	// addresses of the client, servers with one of them are preferred among the least loaded ones, nil disables it
+	localAddresses map[string]bool
+	// TLS configurations of the connections to the servers, which are reached over TCP, when Host is a unix socket. The
+	// ServerName of the ones verifying it is tcpTLSServerName.
+	tcpTLSConfigs []*tls.Config
+	// topology_keys=auto, topologyKeys are set to the zone and region of the client by the connect
+	autoTopology bool
+	// time waited before trying another server after a failed connect
+	connectRetryBackoff time.Duration
+	// time up to which connectRetryBackoff is doubled after every failed connect, 0 keeps it constant
+	connectRetryMaxBackoff time.Duration
+	// time given to every attempt of a load balanced connect, 0 gives every attempt but the last a fraction of the
+	// time left before the deadline of the connect
+	connectAttemptTimeout time.Duration
+	// number of times the first refresh of a cluster is retried if it fails, before connecting without load balancing
+	coldStartRetries int
+	// time waited before retrying the first refresh of a cluster
+	coldStartBackoff time.Duration
+	// connect to the selected server only, rather than also to the Fallbacks of the connection string
+	disableFallbacks bool
+	// query listing the servers of the cluster, LB_QUERY if empty
+	serversQuery string
+	// whether the connection counts of the servers are the num_connections they report rather than the driver's own
+	serverLoad bool
+	// connections a server may have before it is no longer selected, 0 for no limit
+	maxConnsPerHost int
+	// time between probes of the hosts marked unavailable, which are then only made available once they respond, 0
+	// disables probing
+	probeInterval time.Duration
+	// whether the servers of the regions, then of the clouds, of topologyKeys are tried before the rest of the cluster
+	// when no server matching them is available, see FallbackLevel
+	fallbackLadder bool
+	// time over which the servers joining a cluster after its first refresh ramp up to their share of connections, 0
+	// disables it
+	slowStart time.Duration
+	// factor by which slowStart is multiplied for every topology_keys preference after the first the servers are
+	// selected from, 1 ramps the servers of all preferences alike
+	slowStartTierFactor float64
 }
 
 // ParseConfigOptions contains options that control how a config is built such as getsslpassword.
@@ -66,9 +203,147 @@ func (cc *ConnConfig) Copy() *ConnConfig {
 	return newConfig
 }
 
+// cloneWithHost returns a copy of cc connecting to host and port only, as ParseConfig would return it for the connection
+// string of cc with host and port as its only host. Only the fallbacks of cc to its own Host and Port, e.g. the one
+// without TLS of sslmode=prefer, are kept, moved to host and port.
+func (cc *ConnConfig) cloneWithHost(host string, port uint16) *ConnConfig {
+	newConfig := cc.Copy()
+	newConfig.Host = host
+	newConfig.Port = port
+	if isUnixSocket(cc.Host) && !isUnixSocket(host) && len(cc.tcpTLSConfigs) > 0 {
+		// The connection is not made with the TLS settings of the socket, which has none, but with the ones ParseConfig
+		// sets up for TCP hosts.
+		newConfig.TLSConfig = tlsConfigForHost(cc.tcpTLSConfigs[0], host)
+		newConfig.Fallbacks = nil
+		for _, tlsConfig := range cc.tcpTLSConfigs[1:] {
+			newConfig.Fallbacks = append(newConfig.Fallbacks,
+				&pgconn.FallbackConfig{Host: host, Port: port, TLSConfig: tlsConfigForHost(tlsConfig, host)})
+		}
+		return newConfig
+	}
+	if newConfig.TLSConfig != nil && newConfig.TLSConfig.ServerName == cc.Host {
+		newConfig.TLSConfig.ServerName = host
+	}
+	fallbacks := newConfig.Fallbacks
+	newConfig.Fallbacks = nil
+	for _, fb := range fallbacks {
+		if fb.Host != cc.Host || fb.Port != cc.Port {
+			continue
+		}
+		fb.Host = host
+		fb.Port = port
+		if fb.TLSConfig != nil && fb.TLSConfig.ServerName == cc.Host {
+			fb.TLSConfig.ServerName = host
+		}
+		newConfig.Fallbacks = append(newConfig.Fallbacks, fb)
+	}
+	return newConfig
+}
+
+// Host the TLS configurations of ConnConfig.tcpTLSConfigs are set up for.
+const tcpTLSServerName = "localhost"
+
+// isUnixSocket tells if host is the directory of a unix domain socket rather than a TCP host.
+func isUnixSocket(host string) bool {
+	network, _ := pgconn.NetworkAddress(host, 0)
+	return network == "unix"
+}
+
+// tcpTLSConfigs returns the TLS configurations ParseConfig sets up for the TCP hosts of connString, even if it only has
+// unix socket hosts, with tcpTLSServerName as the host.
+func tcpTLSConfigs(connString string, options pgconn.ParseConfigOptions) ([]*tls.Config, error) {
+	if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
+		u, err := url.Parse(connString)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		query.Set("host", tcpTLSServerName) // overrides the hosts of the URL
+		u.RawQuery = query.Encode()
+		connString = u.String()
+	} else {
+		connString += " host=" + tcpTLSServerName
+	}
+	config, err := pgconn.ParseConfigWithOptions(connString, options)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfigs := []*tls.Config{config.TLSConfig}
+	for _, fb := range config.Fallbacks {
+		tlsConfigs = append(tlsConfigs, fb.TLSConfig)
+	}
+	return tlsConfigs, nil
+}
+
+// tlsConfigForHost returns a copy of tlsConfig, one of ConnConfig.tcpTLSConfigs, verifying host.
+func tlsConfigForHost(tlsConfig *tls.Config, host string) *tls.Config {
+	if tlsConfig == nil {
+		return nil
+	}
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == tcpTLSServerName {
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig
+}
+
 // ConnString returns the connection string as parsed by pgx.ParseConfig into pgx.ConnConfig.
 func (cc *ConnConfig) ConnString() string { return cc.connString }
 
+// SetLoadBalance sets the load_balance setting of cc to mode, one of the values load_balance accepts in a connection
+// string, e.g. to "only-rr" for a copy of a config whose connections are to be made to read replicas only.
+func (cc *ConnConfig) SetLoadBalance(mode string) error {
+	if !validateLoadBalance(mode) {
+		return fmt.Errorf("invalid load_balance value: Valid values are only-rr, only-primary, prefer-rr, prefer-primary, any or true")
+	}
+	if mode != "false" && isUnixSocket(cc.Host) && cc.tcpTLSConfigs == nil {
+		tlsConfigs, err := tcpTLSConfigs(cc.connString, pgconn.ParseConfigOptions{})
+		if err != nil {
+			return fmt.Errorf("cannot set up TLS for the servers of unix socket %s: %w", cc.Host, err)
+		}
+		cc.tcpTLSConfigs = tlsConfigs
+	}
+	cc.loadBalance = mode
+	return nil
+}
+
+// FallbackHost is a server a connection attempt falls back to if connecting to the servers before it failed.
+type FallbackHost struct {
+	Host string
+	Port uint16
+	TLS  bool
+}
+
+// EffectiveFallbacks returns the servers a connection with the config falls back to, in order. For the config of a
+// load balanced connection, as returned by Conn.Config, these are the fallbacks left by the load balancer for the
+// server it selected, not the hosts of the connection string.
+func (cc *ConnConfig) EffectiveFallbacks() []FallbackHost {
+	fallbacks := make([]FallbackHost, len(cc.Fallbacks))
+	for i, fb := range cc.Fallbacks {
+		fallbacks[i] = FallbackHost{Host: fb.Host, Port: fb.Port, TLS: fb.TLSConfig != nil}
+	}
+	return fallbacks
+}
+
+// loadCountKey identifies the cluster and the server the connection count of a load balanced connection is kept
+// against, as expected by decrementConnCount.
+func (cc *ConnConfig) loadCountKey() string {
+	cluster := cc.controlHost
+	if cc.clusterName != "" {
+		cluster = cc.clusterName
+	}
+	return cluster + "," + cc.loadCountHost()
+}
+
+// loadCountHost returns the host the connection count of a load balanced connection made with cc is kept against, the
+// private address of its server even when it connected to the public one.
+func (cc *ConnConfig) loadCountHost() string {
+	if cc.countedHost != "" {
+		return cc.countedHost
+	}
+	return cc.Host
+}
+
 // Conn is a PostgreSQL connection handle. It is not safe for concurrent usage. Use a connection pool to manage access
 // to multiple database connections from multiple goroutines.
 type Conn struct {
@@ -94,6 +369,14 @@ type Conn struct {
 	eqb  ExtendedQueryBuilder
 
 	closeCntUpdated bool
+	// generation of the cluster's load information the server of this connection was selected from
+	selectionGeneration uint64
+	// number of servers connected to until this connection succeeded
+	connectAttempts int
+	// how the server of this connection was chosen, nil if the connection is not load balanced
+	lbDecision *LBDecision
+	// config of the load balanced connect, before the selected server was substituted into it
+	lbConfig *ConnConfig
 }
 
 // Identifier a PostgreSQL identifier or name. Identifiers can be composed of
@@ -115,6 +398,9 @@ var (
 	ErrNoRows = errors.New("no rows in result set")
 	// ErrTooManyRows occurs when more rows than expected are returned.
 	ErrTooManyRows = errors.New("too many rows in result set")
+	// ErrTopologyKeysWithoutLoadBalance occurs when topology_keys is given but load_balance is false. It is only returned
+	// by ParseConfig with load_balance_strict=true, otherwise it is logged as a warning.
+	ErrTopologyKeysWithoutLoadBalance = errors.New("topology_keys specified but load_balance is disabled; keys will be ignored")
 )
 
 var errDisabledStatementCache = fmt.Errorf("cannot use QueryExecModeCacheStatement with disabled statement cache")
@@ -128,7 +414,7 @@ func Connect(ctx context.Context, connString string) (*Conn, error) {
 		return nil, err
 	}
 	if connConfig.loadBalance != "false" {
-		return connectLoadBalanced(ctx, connConfig)
+		return connectWithLoadBalancer(ctx, connConfig)
 	} else {
 		return connect(ctx, connConfig)
 	}
@@ -142,7 +428,7 @@ func ConnectWithOptions(ctx context.Context, connString string, options ParseCon
 		return nil, err
 	}
 	if connConfig.loadBalance != "false" {
-		return connectLoadBalanced(ctx, connConfig)
+		return connectWithLoadBalancer(ctx, connConfig)
 	} else {
 		return connect(ctx, connConfig)
 	}
@@ -156,7 +442,7 @@ func ConnectConfig(ctx context.Context, connConfig *ConnConfig) (*Conn, error) {
 	connConfig = connConfig.Copy()
 
 	if connConfig.loadBalance != "false" {
-		return connectLoadBalanced(ctx, connConfig)
+		return connectWithLoadBalancer(ctx, connConfig)
 	} else {
 		return connect(ctx, connConfig)
 	}
@@ -218,42 +504,63 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 			return nil, fmt.Errorf("invalid load_balance value: Valid values are only-rr, only-primary, prefer-rr, prefer-primary, any or true")
 		}
 	}
+	var tcpTLS []*tls.Config
+	if loadBalance != "false" && isUnixSocket(config.Host) {
+		// The control connection is made to the socket, the connections to the servers it lists over TCP.
+		if tcpTLS, err = tcpTLSConfigs(connString, options.ParseConfigOptions); err != nil {
+			return nil, fmt.Errorf("cannot set up TLS for the servers of unix socket %s: %w", config.Host, err)
+		}
+	}
 
 	var topologyKeys map[int][]string = nil
+	autoTopology := false
 	if s, ok := config.RuntimeParams["topology_keys"]; ok {
 		delete(config.RuntimeParams, "topology_keys")
-		if tkeys, err := validateTopologyKeys(s); err == nil {
-			topologyKeys = make(map[int][]string)
-			for _, tk := range tkeys {
-				zones := strings.Split(tk, ":")
-				if len(zones) == 1 {
-					topologyKeys[0] = append(topologyKeys[0], zones[0])
-				} else {
-					num, err := strconv.Atoi(zones[1])
-					if err != nil || num < 1 || num > MAX_PREFERENCE_VALUE {
-						str := "Invalid preference value for " + zones[0] + ": " + zones[1]
-						return nil, fmt.Errorf(str)
-					}
-					topologyKeys[num-1] = append(topologyKeys[num-1], zones[0])
-				}
-			}
-		} else {
+		if s == "auto" {
+			autoTopology = true
+		} else if topologyKeys, err = parseTopologyKeys(s); err != nil {
 			return nil, err
 		}
 	}
 
-	refreshInterval := int64(REFRESH_INTERVAL_SECONDS)
+	loadBalanceStrict := false
+	if s, ok := config.RuntimeParams["load_balance_strict"]; ok {
+		delete(config.RuntimeParams, "load_balance_strict")
+		if b, err := strconv.ParseBool(s); err == nil {
+			loadBalanceStrict = b
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_strict: %v", err)
+		}
+	}
+	if (topologyKeys != nil || autoTopology) && loadBalance == "false" {
+		if loadBalanceStrict {
+			return nil, ErrTopologyKeysWithoutLoadBalance
+		}
+		lbLogf(nil, LBLogLevelWarn, ErrTopologyKeysWithoutLoadBalance.Error())
+	}
+
+	refreshInterval := REFRESH_INTERVAL_SECONDS
 	if s, ok := config.RuntimeParams["yb_servers_refresh_interval"]; ok {
 		delete(config.RuntimeParams, "yb_servers_refresh_interval")
 		if refresh, err := strconv.Atoi(s); err == nil {
 			if refresh >= 0 && refresh <= MAX_INTERVAL_SECONDS {
-				refreshInterval = int64(refresh)
+				refreshInterval = refresh
 			}
 		} else {
 			return nil, fmt.Errorf("invalid refresh_interval: %v", err)
 		}
 	}
 
+	controlConnTimeout := CONTROL_CONN_TIMEOUT
+	if s, ok := config.RuntimeParams["yb_control_conn_timeout"]; ok {
+		delete(config.RuntimeParams, "yb_control_conn_timeout")
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= MAX_INTERVAL_SECONDS {
+			controlConnTimeout = time.Duration(n) * time.Second
+		} else {
+			return nil, fmt.Errorf("invalid yb_control_conn_timeout: %s", s)
+		}
+	}
+
 	fallbackToTopologyKeysOnly := false
 	if s, ok := config.RuntimeParams["fallback_to_topology_keys_only"]; ok {
 		delete(config.RuntimeParams, "fallback_to_topology_keys_only")
@@ -264,6 +571,28 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 		}
 	}
 
+	fallbackLadder := false
+	if s, ok := config.RuntimeParams["load_balance_fallback_ladder"]; ok {
+		delete(config.RuntimeParams, "load_balance_fallback_ladder")
+		if b, err := strconv.ParseBool(s); err == nil {
+			fallbackLadder = b
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_fallback_ladder: %s", s)
+		}
+	}
+
+	intraTierOrdered := false
+	if s, ok := config.RuntimeParams["intra_tier_order"]; ok {
+		delete(config.RuntimeParams, "intra_tier_order")
+		switch s {
+		case "balanced":
+		case "ordered":
+			intraTierOrdered = true
+		default:
+			return nil, fmt.Errorf("invalid intra_tier_order: %s, expected balanced or ordered", s)
+		}
+	}
+
 	failedHostReconnectDelaySecs := int64(DEFAULT_FAILED_HOST_RECONNECT_DELAY_SECS)
 	if s, ok := config.RuntimeParams["failed_host_reconnect_delay_secs"]; ok {
 		delete(config.RuntimeParams, "failed_host_reconnect_delay_secs")
@@ -276,6 +605,364 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 		}
 	}
 
+	selectionWindow := 0
+	if s, ok := config.RuntimeParams["load_balance_selection_window"]; ok {
+		delete(config.RuntimeParams, "load_balance_selection_window")
+		if window, err := strconv.Atoi(s); err == nil && window >= 0 {
+			selectionWindow = window
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_selection_window: %s", s)
+		}
+	}
+
+	quarantineSecs := int64(0)
+	if s, ok := config.RuntimeParams["load_balance_quarantine_secs"]; ok {
+		delete(config.RuntimeParams, "load_balance_quarantine_secs")
+		if quarantine, err := strconv.Atoi(s); err == nil {
+			if quarantine >= 0 && quarantine <= MAX_FAILED_HOST_RECONNECT_DELAY_SECS {
+				quarantineSecs = int64(quarantine)
+			}
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_quarantine_secs: %v", err)
+		}
+	}
+
+	lastResortOriginalHost := false
+	if s, ok := config.RuntimeParams["load_balance_last_resort_original_host"]; ok {
+		delete(config.RuntimeParams, "load_balance_last_resort_original_host")
+		if b, err := strconv.ParseBool(s); err == nil {
+			lastResortOriginalHost = b
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_last_resort_original_host: %v", err)
+		}
+	}
+
+	skipBadRows := false
+	if s, ok := config.RuntimeParams["load_balance_skip_bad_rows"]; ok {
+		delete(config.RuntimeParams, "load_balance_skip_bad_rows")
+		if b, err := strconv.ParseBool(s); err == nil {
+			skipBadRows = b
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_skip_bad_rows: %v", err)
+		}
+	}
+
+	var localAddresses map[string]bool
+	if s, ok := config.RuntimeParams["load_balance_prefer_local"]; ok {
+		delete(config.RuntimeParams, "load_balance_prefer_local")
+		if b, err := strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("invalid load_balance_prefer_local: %v", err)
+		} else if b {
+			localAddresses = localInterfaceAddresses()
+		}
+	}
+	if s, ok := config.RuntimeParams["load_balance_local_addresses"]; ok {
+		delete(config.RuntimeParams, "load_balance_local_addresses")
+		if localAddresses == nil {
+			return nil, fmt.Errorf("invalid load_balance_local_addresses: requires load_balance_prefer_local=true")
+		}
+		localAddresses = make(map[string]bool)
+		for _, h := range strings.Split(s, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				localAddresses[LookupIP(h)] = true
+			}
+		}
+	}
+
+	connectRetries := MAX_RETRIES
+	// yb_connect_max_retries is the name the other YugabyteDB smart drivers use.
+	for _, name := range []string{"yb_connect_max_retries", "load_balance_connect_retries"} {
+		if s, ok := config.RuntimeParams[name]; ok {
+			delete(config.RuntimeParams, name)
+			if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+				connectRetries = n
+			} else {
+				return nil, fmt.Errorf("invalid %s: %s", name, s)
+			}
+		}
+	}
+
+	connectRetryBackoffMs := 0
+	if s, ok := config.RuntimeParams["load_balance_connect_retry_backoff_ms"]; ok {
+		delete(config.RuntimeParams, "load_balance_connect_retry_backoff_ms")
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			connectRetryBackoffMs = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_connect_retry_backoff_ms: %s", s)
+		}
+	}
+
+	connectRetryMaxBackoffMs := 0
+	if s, ok := config.RuntimeParams["load_balance_connect_retry_max_backoff_ms"]; ok {
+		delete(config.RuntimeParams, "load_balance_connect_retry_max_backoff_ms")
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			connectRetryMaxBackoffMs = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_connect_retry_max_backoff_ms: %s", s)
+		}
+	}
+
+	connectAttemptTimeoutMs := 0
+	if s, ok := config.RuntimeParams["load_balance_connect_attempt_timeout_ms"]; ok {
+		delete(config.RuntimeParams, "load_balance_connect_attempt_timeout_ms")
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			connectAttemptTimeoutMs = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_connect_attempt_timeout_ms: %s", s)
+		}
+	}
+
+	coldStartRetries := 0
+	if s, ok := config.RuntimeParams["load_balance_cold_start_retries"]; ok {
+		delete(config.RuntimeParams, "load_balance_cold_start_retries")
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			coldStartRetries = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_cold_start_retries: %s", s)
+		}
+	}
+
+	coldStartBackoffMs := DEFAULT_COLD_START_BACKOFF_MS
+	if s, ok := config.RuntimeParams["load_balance_cold_start_backoff_ms"]; ok {
+		delete(config.RuntimeParams, "load_balance_cold_start_backoff_ms")
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			coldStartBackoffMs = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_cold_start_backoff_ms: %s", s)
+		}
+	}
+
+	disableFallbacks := false
+	if s, ok := config.RuntimeParams["load_balance_disable_fallbacks"]; ok {
+		delete(config.RuntimeParams, "load_balance_disable_fallbacks")
+		if b, err := strconv.ParseBool(s); err == nil {
+			disableFallbacks = b
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_disable_fallbacks: %v", err)
+		}
+	}
+
+	countDecayFraction := float64(0)
+	if s, ok := config.RuntimeParams["load_balance_count_decay"]; ok {
+		delete(config.RuntimeParams, "load_balance_count_decay")
+		if fraction, err := strconv.ParseFloat(s, 64); err == nil {
+			if fraction >= 0 && fraction <= 1 {
+				countDecayFraction = fraction
+			}
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_count_decay: %v", err)
+		}
+	}
+
+	controlDatabase := ""
+	if s, ok := config.RuntimeParams["load_balance_control_database"]; ok {
+		delete(config.RuntimeParams, "load_balance_control_database")
+		controlDatabase = s
+	}
+
+	clusterName := ""
+	if s, ok := config.RuntimeParams["cluster_name"]; ok {
+		delete(config.RuntimeParams, "cluster_name")
+		clusterName = s
+	}
+
+	connectRate := float64(0)
+	if s, ok := config.RuntimeParams["load_balance_connect_rate"]; ok {
+		delete(config.RuntimeParams, "load_balance_connect_rate")
+		if rate, err := strconv.ParseFloat(strings.TrimSuffix(s, "/s"), 64); err == nil && rate >= 0 {
+			connectRate = rate
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_connect_rate: %s", s)
+		}
+	}
+
+	connectRateFail := false
+	if s, ok := config.RuntimeParams["load_balance_connect_rate_policy"]; ok {
+		delete(config.RuntimeParams, "load_balance_connect_rate_policy")
+		switch s {
+		case "wait":
+		case "error":
+			connectRateFail = true
+		default:
+			return nil, fmt.Errorf("invalid load_balance_connect_rate_policy: %s", s)
+		}
+	}
+
+	var allowHosts map[string]bool
+	if s, ok := config.RuntimeParams["load_balance_allow_hosts"]; ok {
+		delete(config.RuntimeParams, "load_balance_allow_hosts")
+		allowHosts = make(map[string]bool)
+		for _, h := range strings.Split(s, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				allowHosts[LookupIP(h)] = true
+			}
+		}
+	}
+
+	var priorityHosts []string
+	if s, ok := config.RuntimeParams["load_balance_priority_hosts"]; ok {
+		delete(config.RuntimeParams, "load_balance_priority_hosts")
+		for _, h := range strings.Split(s, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				priorityHosts = append(priorityHosts, LookupIP(h))
+			}
+		}
+	}
+
+	minEligibleHosts := 1
+	if s, ok := config.RuntimeParams["load_balance_min_eligible_hosts"]; ok {
+		delete(config.RuntimeParams, "load_balance_min_eligible_hosts")
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 {
+			minEligibleHosts = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_min_eligible_hosts: %s", s)
+		}
+	}
+
+	affinityTolerance := 0
+	if s, ok := config.RuntimeParams["load_balance_affinity_tolerance"]; ok {
+		delete(config.RuntimeParams, "load_balance_affinity_tolerance")
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			affinityTolerance = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_affinity_tolerance: %s", s)
+		}
+	}
+
+	latencyAware := false
+	if s, ok := config.RuntimeParams["load_balance_latency_aware"]; ok {
+		delete(config.RuntimeParams, "load_balance_latency_aware")
+		if b, err := strconv.ParseBool(s); err == nil {
+			latencyAware = b
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_latency_aware: %v", err)
+		}
+	}
+
+	tabletAware := false
+	if s, ok := config.RuntimeParams["load_balance_tablet_aware"]; ok {
+		delete(config.RuntimeParams, "load_balance_tablet_aware")
+		if b, err := strconv.ParseBool(s); err == nil {
+			tabletAware = b
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_tablet_aware: %v", err)
+		}
+	}
+
+	controlConns := 1
+	if s, ok := config.RuntimeParams["load_balance_control_conns"]; ok {
+		delete(config.RuntimeParams, "load_balance_control_conns")
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 {
+			controlConns = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_control_conns: %s", s)
+		}
+	}
+
+	var maxReplicaLagMs int64
+	if s, ok := config.RuntimeParams["load_balance_max_replica_lag_ms"]; ok {
+		delete(config.RuntimeParams, "load_balance_max_replica_lag_ms")
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+			maxReplicaLagMs = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_max_replica_lag_ms: %s", s)
+		}
+	}
+
+	serversQuery := ""
+	if s, ok := config.RuntimeParams["yb_servers_query"]; ok {
+		delete(config.RuntimeParams, "yb_servers_query")
+		if strings.TrimSpace(s) == "" {
+			return nil, fmt.Errorf("invalid yb_servers_query: %q", s)
+		}
+		serversQuery = s
+	}
+
+	serverLoad := false
+	_, loadSourceSet := config.RuntimeParams["load_balance_load_source"]
+	if s, ok := config.RuntimeParams["load_balance_load_source"]; ok {
+		delete(config.RuntimeParams, "load_balance_load_source")
+		switch s {
+		case "driver":
+		case "server":
+			serverLoad = true
+		default:
+			return nil, fmt.Errorf("invalid load_balance_load_source: %s", s)
+		}
+	}
+
+	// yb_servers_refresh_load=true is the same as load_balance_load_source=server
+	if s, ok := config.RuntimeParams["yb_servers_refresh_load"]; ok {
+		delete(config.RuntimeParams, "yb_servers_refresh_load")
+		if b, err := strconv.ParseBool(s); err == nil && (!loadSourceSet || b == serverLoad) {
+			serverLoad = b
+		} else {
+			return nil, fmt.Errorf("invalid yb_servers_refresh_load: %s", s)
+		}
+	}
+
+	maxConnsPerHost := 0
+	if s, ok := config.RuntimeParams["load_balance_max_conns_per_host"]; ok {
+		delete(config.RuntimeParams, "load_balance_max_conns_per_host")
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			maxConnsPerHost = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_max_conns_per_host: %s", s)
+		}
+	}
+
+	var slowStartSecs int64
+	if s, ok := config.RuntimeParams["load_balance_slow_start_secs"]; ok {
+		delete(config.RuntimeParams, "load_balance_slow_start_secs")
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+			slowStartSecs = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_slow_start_secs: %s", s)
+		}
+	}
+
+	slowStartTierFactor := float64(1)
+	if s, ok := config.RuntimeParams["load_balance_slow_start_tier_factor"]; ok {
+		delete(config.RuntimeParams, "load_balance_slow_start_tier_factor")
+		if factor, err := strconv.ParseFloat(s, 64); err == nil && factor >= 1 && !math.IsInf(factor, 1) {
+			slowStartTierFactor = factor
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_slow_start_tier_factor: %s", s)
+		}
+	}
+
+	var probeIntervalMs int64
+	if s, ok := config.RuntimeParams["load_balance_probe_interval_ms"]; ok {
+		delete(config.RuntimeParams, "load_balance_probe_interval_ms")
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+			probeIntervalMs = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_probe_interval_ms: %s", s)
+		}
+	}
+
+	circuitFailures := 0
+	if s, ok := config.RuntimeParams["load_balance_circuit_failures"]; ok {
+		delete(config.RuntimeParams, "load_balance_circuit_failures")
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			circuitFailures = n
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_circuit_failures: %s", s)
+		}
+	}
+
+	circuitCooldownSecs := int64(DEFAULT_CIRCUIT_COOLDOWN_SECS)
+	if s, ok := config.RuntimeParams["load_balance_circuit_cooldown_secs"]; ok {
+		delete(config.RuntimeParams, "load_balance_circuit_cooldown_secs")
+		if cooldown, err := strconv.Atoi(s); err == nil {
+			if cooldown >= 0 && cooldown <= MAX_INTERVAL_SECONDS {
+				circuitCooldownSecs = int64(cooldown)
+			}
+		} else {
+			return nil, fmt.Errorf("invalid load_balance_circuit_cooldown_secs: %v", err)
+		}
+	}
+
 	connConfig := &ConnConfig{
 		Config:                       *config,
 		createdByParseConfig:         true,
@@ -283,9 +970,47 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 		controlHost:                  config.Host,
 		loadBalance:                  loadBalance,
 		topologyKeys:                 topologyKeys,
-		refreshInterval:              refreshInterval,
+		autoTopology:                 autoTopology,
+		tcpTLSConfigs:                tcpTLS,
+		RefreshInterval:              time.Duration(refreshInterval) * time.Second,
+		ControlConnTimeout:           controlConnTimeout,
 		fallbackToTopologyKeysOnly:   fallbackToTopologyKeysOnly,
 		failedHostReconnectDelaySecs: failedHostReconnectDelaySecs,
+		selectionWindow:              selectionWindow,
+		quarantineSecs:               quarantineSecs,
+		lastResortOriginalHost:       lastResortOriginalHost,
+		countDecayFraction:           countDecayFraction,
+		controlDatabase:              controlDatabase,
+		clusterName:                  clusterName,
+		connectRate:                  connectRate,
+		connectRateFail:              connectRateFail,
+		allowHosts:                   allowHosts,
+		priorityHosts:                priorityHosts,
+		minEligibleHosts:             minEligibleHosts,
+		circuitFailures:              circuitFailures,
+		circuitCooldownSecs:          circuitCooldownSecs,
+		affinityTolerance:            affinityTolerance,
+		intraTierOrdered:             intraTierOrdered,
+		controlConns:                 controlConns,
+		latencyAware:                 latencyAware,
+		tabletAware:                  tabletAware,
+		maxReplicaLagMs:              maxReplicaLagMs,
+		skipBadRows:                  skipBadRows,
+		localAddresses:               localAddresses,
+		MaxConnectRetries:            connectRetries,
+		connectRetryBackoff:          time.Duration(connectRetryBackoffMs) * time.Millisecond,
+		connectRetryMaxBackoff:       time.Duration(connectRetryMaxBackoffMs) * time.Millisecond,
+		connectAttemptTimeout:        time.Duration(connectAttemptTimeoutMs) * time.Millisecond,
+		coldStartRetries:             coldStartRetries,
+		coldStartBackoff:             time.Duration(coldStartBackoffMs) * time.Millisecond,
+		disableFallbacks:             disableFallbacks,
+		serversQuery:                 serversQuery,
+		serverLoad:                   serverLoad,
+		maxConnsPerHost:              maxConnsPerHost,
+		probeInterval:                time.Duration(probeIntervalMs) * time.Millisecond,
+		fallbackLadder:               fallbackLadder,
+		slowStart:                    time.Duration(slowStartSecs) * time.Second,
+		slowStartTierFactor:          slowStartTierFactor,
 		StatementCacheCapacity:       statementCacheCapacity,
 		DescriptionCacheCapacity:     descriptionCacheCapacity,
 		DefaultQueryExecMode:         defaultQueryExecMode,
@@ -313,6 +1038,19 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 //      Possible values: "true" and "false". Default: false
 //   - topology_keys
 //      YugabyteDB placement information in the format "cloudname.regionname.zonename". Default: empty
+//      "auto" detects the cloud, region and zone of the client with the instance metadata service of AWS, GCP or Azure,
+//      and prefers the servers of its zone, then of its region.
+//   - intra_tier_order
+//      Possible values: "balanced" and "ordered". Whether the servers of the topology_keys sharing a preference are
+//      balanced across, or the keys are preferred in the order they are listed. Default: balanced
+//   - load_balance_strict
+//      Return an error instead of logging a warning when topology_keys is given but load_balance is false. Default: false
+//   - load_balance_slow_start_secs
+//      Seconds over which a server joining the cluster after its first refresh ramps up to its share of connections.
+//      Default: 0, disabled
+//   - load_balance_slow_start_tier_factor
+//      Factor by which load_balance_slow_start_secs is multiplied for every topology_keys preference after the first.
+//      Default: 1
 
 func ParseConfig(connString string) (*ConnConfig, error) {
 	return ParseConfigWithOptions(connString, ParseConfigOptions{})
@@ -334,9 +1072,10 @@ func connect(ctx context.Context, config *ConnConfig) (c *Conn, err error) {
 	}
 
 	c = &Conn{
-		config:      config,
-		typeMap:     pgtype.NewMap(),
-		queryTracer: config.Tracer,
+		config:          config,
+		typeMap:         pgtype.NewMap(),
+		queryTracer:     config.Tracer,
+		connectAttempts: 1,
 	}
 
 	if t, ok := c.queryTracer.(BatchTracer); ok {
@@ -381,7 +1120,7 @@ func (c *Conn) Close(ctx context.Context) error {
 	if c.IsClosed() {
 		if !c.closeCntUpdated && c.config.loadBalance != "false" {
 			c.closeCntUpdated = true
-			decrementConnCount(c.config.controlHost + "," + c.config.Host)
+			c.config.clusterManager().decrementConnCount(c.config.loadCountKey())
 		}
 		return nil
 	}
@@ -390,7 +1129,7 @@ func (c *Conn) Close(ctx context.Context) error {
 
 	if !c.closeCntUpdated && c.config.loadBalance != "false" {
 		c.closeCntUpdated = true
-		decrementConnCount(c.config.controlHost + "," + c.config.Host)
+		c.config.clusterManager().decrementConnCount(c.config.loadCountKey())
 	}
 	return err
 }
@@ -540,6 +1279,20 @@ func (c *Conn) PgConn() *pgconn.PgConn { return c.pgConn }
 // TypeMap returns the connection info used for this connection.
 func (c *Conn) TypeMap() *pgtype.Map { return c.typeMap }
 
+// SelectionGeneration returns the generation of the cluster topology the server of a load balanced connection was
+// selected from. The generation is incremented whenever a refresh finds servers added to or removed from the cluster,
+// so connections with an older generation than a newer connection were established against an outdated topology. It
+// is 0 if the connection is not load balanced.
+func (c *Conn) SelectionGeneration() uint64 { return c.selectionGeneration }
+
+// ConnectAttempts returns the number of connection attempts it took to establish the connection. A load balanced
+// connection reports more than 1 if the servers selected first could not be connected to.
+func (c *Conn) ConnectAttempts() int { return c.connectAttempts }
+
+// LoadBalanceInfo returns how the server of a load balanced connection was chosen. It is nil if the connection is not
+// load balanced.
+func (c *Conn) LoadBalanceInfo() *LBDecision { return c.lbDecision }
+
 // Config returns a copy of config that was used to establish this connection.
 func (c *Conn) Config() *ConnConfig { return c.config.Copy() }
 
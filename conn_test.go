@@ -205,6 +205,31 @@ func TestParseConfigErrors(t *testing.T) {
 		expectedErrSubstring string
 	}{
 		{"default_query_exec_mode=does_not_exist", "does_not_exist"},
+		{"intra_tier_order=random", "invalid intra_tier_order"},
+		{"load_balance_max_replica_lag_ms=-1", "invalid load_balance_max_replica_lag_ms"},
+		{"load_balance_prefer_local=maybe", "invalid load_balance_prefer_local"},
+		{"load_balance_local_addresses=127.0.0.1", "invalid load_balance_local_addresses"},
+		{"load_balance_prefer_local=false load_balance_local_addresses=127.0.0.1", "invalid load_balance_local_addresses"},
+		{"load_balance_connect_retries=-1", "invalid load_balance_connect_retries"},
+		{"yb_connect_max_retries=many", "invalid yb_connect_max_retries"},
+		{"yb_control_conn_timeout=0", "invalid yb_control_conn_timeout"},
+		{"yb_control_conn_timeout=601", "invalid yb_control_conn_timeout"},
+		{"load_balance_connect_retry_max_backoff_ms=-1", "invalid load_balance_connect_retry_max_backoff_ms"},
+		{"load_balance_connect_attempt_timeout_ms=-1", "invalid load_balance_connect_attempt_timeout_ms"},
+		{"load_balance_cold_start_backoff_ms=soon", "invalid load_balance_cold_start_backoff_ms"},
+		{"load_balance_disable_fallbacks=sometimes", "invalid load_balance_disable_fallbacks"},
+		{"yb_servers_query=' '", "invalid yb_servers_query"},
+		{"load_balance_load_source=client", "invalid load_balance_load_source"},
+		{"load_balance_fallback_ladder=maybe", "invalid load_balance_fallback_ladder"},
+		{"yb_servers_refresh_load=yes", "invalid yb_servers_refresh_load"},
+		{"load_balance_load_source=driver yb_servers_refresh_load=true", "invalid yb_servers_refresh_load"},
+		{"load_balance_max_conns_per_host=-1", "invalid load_balance_max_conns_per_host"},
+		{"load_balance_probe_interval_ms=soon", "invalid load_balance_probe_interval_ms"},
+		{"load_balance_latency_aware=maybe", "invalid load_balance_latency_aware"},
+		{"load_balance_tablet_aware=maybe", "invalid load_balance_tablet_aware"},
+		{"load_balance_control_conns=0", "invalid load_balance_control_conns"},
+		{"topology_keys=aws.us-east-1.us-east-1a:0", "Invalid preference value"},
+		{"topology_keys=aws.us-east-1.us-east-1a:11", "Invalid preference value"},
 	} {
 		config, err := pgx.ParseConfig(tt.connString)
 		require.Nil(t, config)
@@ -212,6 +237,28 @@ func TestParseConfigErrors(t *testing.T) {
 	}
 }
 
+func TestParseConfigTopologyKeysWithoutLoadBalance(t *testing.T) {
+	t.Parallel()
+
+	for _, connString := range []string{
+		"topology_keys=aws.us-east-1.us-east-1a load_balance_strict=true",
+		"topology_keys=aws.us-east-1.us-east-1a load_balance=false load_balance_strict=true",
+	} {
+		_, err := pgx.ParseConfig(connString)
+		require.ErrorIsf(t, err, pgx.ErrTopologyKeysWithoutLoadBalance, "connString: `%s`", connString)
+	}
+
+	for _, connString := range []string{
+		"topology_keys=aws.us-east-1.us-east-1a load_balance=false",
+		"topology_keys=aws.us-east-1.us-east-1a load_balance=true load_balance_strict=true",
+		"load_balance=false load_balance_strict=true",
+	} {
+		config, err := pgx.ParseConfig(connString)
+		require.NoErrorf(t, err, "connString: `%s`", connString)
+		require.Empty(t, config.RuntimeParams["load_balance_strict"])
+	}
+}
+
 func TestExec(t *testing.T) {
 	t.Parallel()
 
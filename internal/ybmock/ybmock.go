@@ -0,0 +1,467 @@
+// Package ybmock provides a fake YugabyteDB cluster to test the load balancer against.
+//
+// Every node of a Cluster listens on its own loopback address, accepts unauthenticated connections and answers the
+// yb_servers() query over the simple protocol. Connection strings returned by Cluster.ConnString use the
+// simple_protocol query exec mode accordingly.
+package ybmock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yugabyte/pgx/v5/pgconn"
+	"github.com/yugabyte/pgx/v5/pgproto3"
+)
+
+// Range of the loopback subnets given to clusters. Subnet s is 127.x.y.0/24 with x = s / 256 and y = s % 256.
+const (
+	firstSubnet = 10
+	lastSubnet  = 0xfeff
+)
+
+// subnets hands out a distinct loopback subnet to every cluster so that clusters never share load balancing
+// information. The load balancer keeps the information of a cluster once its test finished, so a subnet returned by
+// a cluster is only handed out again once every other subnet was, e.g. by a large -count.
+var subnets = struct {
+	sync.Mutex
+	next     int
+	returned []int
+}{next: firstSubnet}
+
+// takeSubnet returns an unused subnet, which is returned once t finishes.
+func takeSubnet(t testing.TB) int {
+	subnets.Lock()
+	defer subnets.Unlock()
+	var subnet int
+	switch {
+	case subnets.next <= lastSubnet:
+		subnet = subnets.next
+		subnets.next++
+	case len(subnets.returned) > 0:
+		subnet = subnets.returned[0]
+		subnets.returned = subnets.returned[1:]
+	default:
+		require.FailNow(t, "ran out of fake cluster subnets")
+	}
+	t.Cleanup(func() {
+		subnets.Lock()
+		defer subnets.Unlock()
+		subnets.returned = append(subnets.returned, subnet)
+	})
+	return subnet
+}
+
+// Node is a tserver of a Cluster. Once the cluster is serving, its fields must only be modified within
+// Cluster.Update.
+type Node struct {
+	Host     string
+	Port     uint16
+	NodeType string
+	Cloud    string
+	Region   string
+	Zone     string
+	PublicIP string
+	// UUID identifies the node whatever its address, see MoveNode.
+	UUID     string
+	NumConns int
+	// RejectConnects is the number of upcoming connection attempts to fail during startup.
+	RejectConnects int
+
+	ln            net.Listener
+	extraLns      []net.Listener
+	startupParams []map[string]string
+}
+
+// Column is a column of the yb_servers() output. Value returns its text representation for a node.
+type Column struct {
+	Name  string
+	OID   uint32
+	Value func(n *Node) string
+}
+
+// ServersColumns are the columns of yb_servers() used by the load balancer.
+var ServersColumns = []Column{
+	{"host", 25, func(n *Node) string { return n.Host }},
+	{"port", 23, func(n *Node) string { return strconv.Itoa(int(n.Port)) }},
+	{"num_connections", 23, func(n *Node) string { return strconv.Itoa(n.NumConns) }},
+	{"node_type", 25, func(n *Node) string { return n.NodeType }},
+	{"cloud", 25, func(n *Node) string { return n.Cloud }},
+	{"region", 25, func(n *Node) string { return n.Region }},
+	{"zone", 25, func(n *Node) string { return n.Zone }},
+	{"public_ip", 25, func(n *Node) string { return n.PublicIP }},
+	{"uuid", 25, func(n *Node) string { return n.UUID }},
+}
+
+// Cluster is a fake YugabyteDB cluster.
+type Cluster struct {
+	t      testing.TB
+	subnet int
+
+	mu    sync.Mutex
+	Nodes []*Node
+	// Columns returned by yb_servers(), defaults to ServersColumns.
+	Columns []Column
+	// ServersQueries is the number of yb_servers() queries served.
+	ServersQueries int
+	// QueryHandler, if set, is called for every query. It returns the error to answer the query with, if any. The
+	// query fails with the code of the error if it is a *pgconn.PgError, with XX000 otherwise.
+	QueryHandler func(n *Node, sql string) error
+	// ResultHandler, if set, is called for the queries other than yb_servers() which QueryHandler did not fail. The
+	// query is answered with the result it returns, with no rows if it is nil.
+	ResultHandler func(n *Node, sql string) *Result
+	conns         []net.Conn
+}
+
+// Result is the answer of ResultHandler to a query, its rows are given in the text format.
+type Result struct {
+	Columns []ResultColumn
+	Rows    [][]string
+}
+
+// ResultColumn is a column of a Result.
+type ResultColumn struct {
+	Name string
+	OID  uint32
+}
+
+// NewCluster starts a cluster with one primary node per placement. Placements are given as "cloud.region.zone". The
+// cluster is stopped when the test finishes.
+func NewCluster(t testing.TB, placements ...string) *Cluster {
+	c := &Cluster{t: t, subnet: takeSubnet(t), Columns: ServersColumns}
+	for _, p := range placements {
+		c.AddNode("primary", p)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// address returns the address i of the subnet of the cluster.
+func (c *Cluster) address(i int) string {
+	return fmt.Sprintf("127.%d.%d.%d", c.subnet>>8, c.subnet&0xff, i)
+}
+
+// AddNode starts a new node. It is only visible to the driver after its next refresh.
+func (c *Cluster) AddNode(nodeType, placement string) *Node {
+	parts := strings.Split(placement, ".")
+	require.Len(c.t, parts, 3)
+
+	c.mu.Lock()
+	n := &Node{
+		Host:     c.address(len(c.Nodes) + 1),
+		NodeType: nodeType,
+		Cloud:    parts[0],
+		Region:   parts[1],
+		Zone:     parts[2],
+		UUID:     fmt.Sprintf("00000000-0000-0000-%04x-%012x", c.subnet, len(c.Nodes)+1),
+	}
+	c.Nodes = append(c.Nodes, n)
+	c.mu.Unlock()
+
+	c.Start(n)
+	return n
+}
+
+// RemoveNode drops n from the yb_servers() output and stops it.
+func (c *Cluster) RemoveNode(n *Node) {
+	c.Stop(n)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.Nodes {
+		if c.Nodes[i] == n {
+			c.Nodes = append(c.Nodes[:i], c.Nodes[i+1:]...)
+			break
+		}
+	}
+}
+
+// Update runs fn with the cluster locked. It must be used to modify the cluster or its nodes once it is serving.
+func (c *Cluster) Update(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn()
+}
+
+// ConnectCount returns the number of connections n has accepted.
+func (c *Cluster) ConnectCount(n *Node) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(n.startupParams)
+}
+
+// StartupParams returns the startup parameters of every connection n has accepted.
+func (c *Cluster) StartupParams(n *Node) []map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]map[string]string(nil), n.startupParams...)
+}
+
+// Hosts returns the addresses of the nodes.
+func (c *Cluster) Hosts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hosts := make([]string, len(c.Nodes))
+	for i, n := range c.Nodes {
+		hosts[i] = n.Host
+	}
+	return hosts
+}
+
+// Start makes n accept connections. A previously stopped node comes back on the same address.
+func (c *Cluster) Start(n *Node) {
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(int(n.Port)))
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(c.t, err)
+
+	c.mu.Lock()
+	n.ln = ln
+	n.Port = uint16(ln.Addr().(*net.TCPAddr).Port)
+	c.mu.Unlock()
+
+	go c.accept(n, ln)
+}
+
+// MoveNode restarts n on another address with the same UUID and port, like a rescheduled Kubernetes pod. The
+// connections it has are dropped.
+func (c *Cluster) MoveNode(n *Node) {
+	c.Stop(n)
+	c.mu.Lock()
+	n.Host = c.address(200 + int(net.ParseIP(n.Host).To4()[3]))
+	c.mu.Unlock()
+	c.Start(n)
+}
+
+// ServePublicIP sets the public_ip of n to a loopback address of its own and makes n accept connections on it too.
+func (c *Cluster) ServePublicIP(n *Node) {
+	ip := c.address(100 + int(net.ParseIP(n.Host).To4()[3]))
+	c.ListenOn(n, ip)
+	c.mu.Lock()
+	n.PublicIP = ip
+	c.mu.Unlock()
+}
+
+// ListenOn makes n also accept connections on host, on the same port. The node is not aware of the address.
+func (c *Cluster) ListenOn(n *Node, host string) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(int(n.Port))))
+	require.NoError(c.t, err)
+
+	c.mu.Lock()
+	n.extraLns = append(n.extraLns, ln)
+	c.mu.Unlock()
+
+	go c.accept(n, ln)
+}
+
+// ListenOnUnix makes n also accept connections on a unix domain socket in dir, like a server started with
+// unix_socket_directories=dir.
+func (c *Cluster) ListenOnUnix(n *Node, dir string) {
+	ln, err := net.Listen("unix", filepath.Join(dir, ".s.PGSQL."+strconv.Itoa(int(n.Port))))
+	require.NoError(c.t, err)
+
+	c.mu.Lock()
+	n.extraLns = append(n.extraLns, ln)
+	c.mu.Unlock()
+
+	go c.accept(n, ln)
+}
+
+func (c *Cluster) accept(n *Node, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		c.conns = append(c.conns, conn)
+		c.mu.Unlock()
+		go c.serve(n, conn)
+	}
+}
+
+// Stop makes n refuse new connections and drops the connections it already has.
+func (c *Cluster) Stop(n *Node) {
+	c.mu.Lock()
+	if n.ln != nil {
+		n.ln.Close()
+		n.ln = nil
+	}
+	for _, ln := range n.extraLns {
+		ln.Close()
+	}
+	n.extraLns = nil
+	c.mu.Unlock()
+	c.DropConnections(n)
+}
+
+// DropConnections closes the connections n has, n keeps accepting new ones.
+func (c *Cluster) DropConnections(n *Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.conns[:0]
+	for _, conn := range c.conns {
+		if host, _, _ := net.SplitHostPort(conn.LocalAddr().String()); host == n.Host || host == n.PublicIP {
+			conn.Close()
+		} else {
+			conns = append(conns, conn)
+		}
+	}
+	c.conns = conns
+}
+
+// Close stops all nodes.
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	nodes := append([]*Node(nil), c.Nodes...)
+	c.mu.Unlock()
+	for _, n := range nodes {
+		c.Stop(n)
+	}
+}
+
+// ConnString returns a load balanced connection string pointing at the first node of the cluster. params are
+// appended to it.
+func (c *Cluster) ConnString(params string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := fmt.Sprintf("postgres://yugabyte@%s:%d/yugabyte?sslmode=disable&default_query_exec_mode=simple_protocol&load_balance=true",
+		c.Nodes[0].Host, c.Nodes[0].Port)
+	if params != "" {
+		s += "&" + params
+	}
+	return s
+}
+
+func (c *Cluster) serve(n *Node, conn net.Conn) {
+	defer conn.Close()
+	backend := pgproto3.NewBackend(conn, conn)
+
+	msg, err := backend.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+	if _, ok := msg.(*pgproto3.SSLRequest); ok {
+		if _, err := conn.Write([]byte("N")); err != nil {
+			return
+		}
+		if msg, err = backend.ReceiveStartupMessage(); err != nil {
+			return
+		}
+	}
+	startup, ok := msg.(*pgproto3.StartupMessage)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	reject := n.RejectConnects > 0
+	if reject {
+		n.RejectConnects--
+	} else {
+		n.startupParams = append(n.startupParams, startup.Parameters)
+	}
+	c.mu.Unlock()
+	if reject {
+		backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P03", Message: "the database system is starting up"})
+		backend.Flush()
+		return
+	}
+
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if backend.Flush() != nil {
+		return
+	}
+
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			c.handleQuery(backend, n, msg.String)
+		case *pgproto3.Terminate:
+			return
+		}
+		if backend.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (c *Cluster) handleQuery(backend *pgproto3.Backend, n *Node, sql string) {
+	c.mu.Lock()
+	handler := c.QueryHandler
+	c.mu.Unlock()
+	if handler != nil {
+		if err := handler(n, sql); err != nil {
+			code, message := "XX000", err.Error()
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) {
+				code, message = pgErr.Code, pgErr.Message
+			}
+			backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: code, Message: message})
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			return
+		}
+	}
+
+	if !strings.Contains(sql, "yb_servers()") {
+		c.mu.Lock()
+		resultHandler := c.ResultHandler
+		c.mu.Unlock()
+		if resultHandler != nil {
+			if result := resultHandler(n, sql); result != nil {
+				sendResult(backend, result)
+				return
+			}
+		}
+		backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")})
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ServersQueries++
+
+	fields := make([]pgproto3.FieldDescription, len(c.Columns))
+	for i, col := range c.Columns {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(col.Name), DataTypeOID: col.OID, DataTypeSize: -1, TypeModifier: -1}
+	}
+	backend.Send(&pgproto3.RowDescription{Fields: fields})
+	for _, n := range c.Nodes {
+		values := make([][]byte, len(c.Columns))
+		for i, col := range c.Columns {
+			values[i] = []byte(col.Value(n))
+		}
+		backend.Send(&pgproto3.DataRow{Values: values})
+	}
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("SELECT %d", len(c.Nodes)))})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+}
+
+func sendResult(backend *pgproto3.Backend, result *Result) {
+	fields := make([]pgproto3.FieldDescription, len(result.Columns))
+	for i, col := range result.Columns {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(col.Name), DataTypeOID: col.OID, DataTypeSize: -1, TypeModifier: -1}
+	}
+	backend.Send(&pgproto3.RowDescription{Fields: fields})
+	for _, row := range result.Rows {
+		values := make([][]byte, len(row))
+		for i, v := range row {
+			values[i] = []byte(v)
+		}
+		backend.Send(&pgproto3.DataRow{Values: values})
+	}
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("SELECT %d", len(result.Rows)))})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+}
@@ -0,0 +1,71 @@
+// Package lbdebug serves the state of the YugabyteDB load balancer over HTTP.
+package lbdebug
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/yugabyte/pgx/v5"
+)
+
+var page = template.Must(template.New("lbdebug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>pgx load balancer</title></head>
+<body>
+{{range .Clusters}}
+<h2>Cluster {{.Name}}</h2>
+<p>
+Control host: {{.ControlHost}}<br>
+Last refresh: {{.LastRefresh}}<br>
+Generation: {{.Generation}}<br>
+Address type: {{.AddressType}}<br>
+load_balance: {{.Config.LoadBalance}}, topology_keys: {{.Config.TopologyKeys}},
+fallback_to_topology_keys_only: {{.Config.FallbackToTopologyKeysOnly}},
+refresh interval: {{.Config.RefreshIntervalSecs}}s, failed host reconnect delay: {{.Config.FailedHostReconnectDelaySecs}}s
+</p>
+<table border="1">
+<tr><th>Host</th><th>Port</th><th>Public IP</th><th>Node type</th><th>Placement</th><th>Connections</th><th>Control connection</th><th>Unavailable since</th><th>Asymmetric reachability since</th></tr>
+{{$unavailable := .UnavailableHosts}}
+{{$asymmetric := .AsymmetricHosts}}
+{{range .Hosts}}
+<tr><td>{{.Host}}</td><td>{{.Port}}</td><td>{{.PublicIP}}</td><td>{{.NodeType}}</td><td>{{.Placement}}</td><td>{{.Connections}}</td><td>{{.ControlConnection}}</td><td>{{index $unavailable .Host}}</td><td>{{index $asymmetric .Host}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>No load balanced connection has been made yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// LoadBalancerDebugHandler returns a handler serving pgx.DumpLoadBalancerState. The state is served as HTML if the
+// request has the query parameter format=html or accepts text/html, and as JSON otherwise.
+func LoadBalancerDebugHandler() http.Handler {
+	return newHandler(pgx.DumpLoadBalancerState)
+}
+
+// ClusterManagerDebugHandler returns a handler serving the state of the clusters load balanced connections were made
+// to with m, see pgx.ClusterManager.State, like LoadBalancerDebugHandler.
+func ClusterManagerDebugHandler(m *pgx.ClusterManager) http.Handler {
+	return newHandler(m.State)
+}
+
+func newHandler(dump func() pgx.LoadBalancerState) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := dump()
+		if r.URL.Query().Get("format") == "html" ||
+			(r.URL.Query().Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/html")) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := page.Execute(w, state); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
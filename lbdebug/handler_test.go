@@ -0,0 +1,72 @@
+package lbdebug_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/internal/ybmock"
+	"github.com/yugabyte/pgx/v5/lbdebug"
+)
+
+func TestLoadBalancerDebugHandler(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b")
+	cluster.Update(func() { cluster.Nodes[1].PublicIP = "10.0.0.2" })
+	conn, err := pgx.Connect(context.Background(), cluster.ConnString("topology_keys=aws.us-east-1.us-east-1b"))
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	rec := httptest.NewRecorder()
+	lbdebug.LoadBalancerDebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pgx/lb", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var state pgx.LoadBalancerState
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	require.Len(t, state.Clusters, 1)
+	c := state.Clusters[0]
+	assert.Equal(t, cluster.Nodes[0].Host, c.Name)
+	assert.Equal(t, cluster.Nodes[0].Host, c.ControlHost)
+	assert.Equal(t, "private", c.AddressType)
+	assert.Equal(t, "true", c.Config.LoadBalance)
+	assert.Equal(t, map[int][]string{0: {"aws.us-east-1.us-east-1b"}}, c.Config.TopologyKeys)
+	assert.Empty(t, c.UnavailableHosts)
+	assert.Equal(t, []pgx.HostState{
+		{Host: cluster.Nodes[0].Host, Port: cluster.Nodes[0].Port, NodeType: "primary", Placement: "aws.us-east-1.us-east-1a", Connections: 0, ControlConnection: true},
+		{Host: cluster.Nodes[1].Host, Port: cluster.Nodes[1].Port, PublicIP: "10.0.0.2", NodeType: "primary", Placement: "aws.us-east-1.us-east-1b", Connections: 1},
+	}, c.Hosts)
+
+	rec = httptest.NewRecorder()
+	lbdebug.LoadBalancerDebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pgx/lb?format=html", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "<td>"+cluster.Nodes[1].Host+"</td>")
+	assert.Contains(t, rec.Body.String(), "aws.us-east-1.us-east-1b")
+}
+
+func TestClusterManagerDebugHandler(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	m := pgx.NewClusterManager()
+	defer m.Shutdown()
+	config, err := pgx.ParseConfig(cluster.ConnString(""))
+	require.NoError(t, err)
+	config.ClusterManager = m
+	conn, err := pgx.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	rec := httptest.NewRecorder()
+	lbdebug.ClusterManagerDebugHandler(m).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pgx/lb", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var state pgx.LoadBalancerState
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	require.Len(t, state.Clusters, 1)
+	assert.Equal(t, cluster.Nodes[0].Host, state.Clusters[0].Name)
+	require.Len(t, state.Clusters[0].Hosts, 1)
+	assert.Equal(t, 1, state.Clusters[0].Hosts[0].Connections)
+}
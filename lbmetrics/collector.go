@@ -0,0 +1,227 @@
+// Package lbmetrics exports metrics of the YugabyteDB load balancer to Prometheus.
+//
+// It is a module of its own so that pgx itself does not depend on the Prometheus client. It requires the release of pgx
+// adding the load balancer statistics, which must therefore be tagged before it, see CONTRIBUTING.md.
+package lbmetrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yugabyte/pgx/v5"
+)
+
+// Kinds of the fallbacks counted by the pgx_lb_fallbacks_total metric.
+const (
+	// FallbackTopology is a server selected outside of topology_keys because none matching them was available.
+	FallbackTopology = "topology"
+	// FallbackInternal is a host tried other than the selected server, as the Fallbacks of the connection string or
+	// another address of the server.
+	FallbackInternal = "internal"
+	// FallbackOriginalHost is a connection made to the host of the connection string without load balancing.
+	FallbackOriginalHost = "original_host"
+)
+
+// Collector is a prometheus.Collector exporting the number of connections to every server known to the load
+// balancer, the servers marked as unavailable, the duration and failures of the refreshes of the load information,
+// and the retries and fallbacks of load balanced connects.
+//
+// The refresh, retry and fallback metrics are only recorded for connections made with a config whose Tracer is the
+// Collector, which implements pgx.LBConnectTracer, pgx.LBRefreshTracer and pgx.LBHostSelectTracer. It also implements
+// pgx.QueryTracer, without tracing queries, so it can be assigned to pgx.ConnConfig.Tracer.
+type Collector struct {
+	manager *pgx.ClusterManager
+	events  <-chan pgx.LBEvent
+	done    chan struct{}
+	once    sync.Once
+
+	connections     *prometheus.Desc
+	markedAway      *prometheus.CounterVec
+	refreshDuration *prometheus.HistogramVec
+	refreshFailures *prometheus.CounterVec
+	connectRetries  *prometheus.CounterVec
+	fallbacks       *prometheus.CounterVec
+}
+
+// NewCollector returns a Collector for the load information and the events of m, the default ClusterManager of pgx if
+// m is nil. Close must be called once the Collector is no longer used.
+func NewCollector(m *pgx.ClusterManager) *Collector {
+	var events <-chan pgx.LBEvent
+	if m != nil {
+		events = m.SubscribeLoadBalancerEvents()
+	} else {
+		events = pgx.SubscribeLoadBalancerEvents()
+	}
+	c := &Collector{
+		manager: m,
+		events:  events,
+		done:    make(chan struct{}),
+		connections: prometheus.NewDesc("pgx_lb_host_connections",
+			"Number of connections the load balancer counts against a server.",
+			[]string{"cluster", "host", "node_type"}, nil),
+		markedAway: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pgx_lb_host_marked_away_total",
+			Help: "Number of times a server was marked as unavailable.",
+		}, []string{"cluster", "host"}),
+		refreshDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pgx_lb_refresh_duration_seconds",
+			Help:    "Duration of the refreshes of the servers of a cluster.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"cluster"}),
+		refreshFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pgx_lb_refresh_failures_total",
+			Help: "Number of failed refreshes of the servers of a cluster.",
+		}, []string{"cluster"}),
+		connectRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pgx_lb_connect_retries_total",
+			Help: "Number of servers connected to after the first one by load balanced connects.",
+		}, []string{"cluster"}),
+		fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pgx_lb_fallbacks_total",
+			Help: "Number of fallbacks of load balanced connects, by kind.",
+		}, []string{"cluster", "kind"}),
+	}
+	go c.consumeEvents()
+	return c
+}
+
+func (c *Collector) consumeEvents() {
+	for {
+		select {
+		case e, ok := <-c.events:
+			if !ok {
+				return
+			}
+			if e.Type == pgx.LBEventHostMarkedAway {
+				c.markedAway.WithLabelValues(e.ClusterName, e.Host).Inc()
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Close stops counting the hosts marked as unavailable.
+func (c *Collector) Close() {
+	c.once.Do(func() {
+		close(c.done)
+		if c.manager != nil {
+			c.manager.UnsubscribeLoadBalancerEvents(c.events)
+		} else {
+			pgx.UnsubscribeLoadBalancerEvents(c.events)
+		}
+	})
+}
+
+func (c *Collector) state() pgx.LoadBalancerState {
+	if c.manager != nil {
+		return c.manager.State()
+	}
+	return pgx.DumpLoadBalancerState()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	c.markedAway.Describe(ch)
+	c.refreshDuration.Describe(ch)
+	c.refreshFailures.Describe(ch)
+	c.connectRetries.Describe(ch)
+	c.fallbacks.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, cluster := range c.state().Clusters {
+		for _, h := range cluster.Hosts {
+			ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(h.Connections),
+				cluster.Name, h.Host, h.NodeType)
+		}
+	}
+	c.markedAway.Collect(ch)
+	c.refreshDuration.Collect(ch)
+	c.refreshFailures.Collect(ch)
+	c.connectRetries.Collect(ch)
+	c.fallbacks.Collect(ch)
+}
+
+type ctxKey int
+
+const (
+	connectKey ctxKey = iota
+	refreshKey
+	selectClusterKey
+)
+
+// connectStart holds the name of the cluster of a load balanced connect, known once a server is selected.
+type connectStart struct {
+	cluster string
+}
+
+type refreshStart struct {
+	cluster string
+	start   time.Time
+}
+
+func (c *Collector) TraceLBConnectStart(ctx context.Context, data pgx.TraceLBConnectStartData) context.Context {
+	return context.WithValue(ctx, connectKey, &connectStart{cluster: data.ConnConfig.Host})
+}
+
+func (c *Collector) TraceLBConnectEnd(ctx context.Context, data pgx.TraceLBConnectEndData) {
+	start, ok := ctx.Value(connectKey).(*connectStart)
+	if !ok {
+		return
+	}
+	cluster := start.cluster
+	if data.Attempts > 1 {
+		c.connectRetries.WithLabelValues(cluster).Add(float64(data.Attempts - 1))
+	}
+	if data.Conn == nil {
+		return
+	}
+	if d := data.Conn.LoadBalanceInfo(); d != nil {
+		if d.Fallback {
+			c.fallbacks.WithLabelValues(cluster, FallbackOriginalHost).Inc()
+		}
+		if d.InternalFallbacks > 0 {
+			c.fallbacks.WithLabelValues(cluster, FallbackInternal).Add(float64(d.InternalFallbacks))
+		}
+	}
+}
+
+func (c *Collector) TraceLBRefreshStart(ctx context.Context, data pgx.TraceLBRefreshStartData) context.Context {
+	return context.WithValue(ctx, refreshKey, refreshStart{cluster: data.ClusterName, start: time.Now()})
+}
+
+func (c *Collector) TraceLBRefreshEnd(ctx context.Context, data pgx.TraceLBRefreshEndData) {
+	r, ok := ctx.Value(refreshKey).(refreshStart)
+	if !ok {
+		return
+	}
+	c.refreshDuration.WithLabelValues(r.cluster).Observe(time.Since(r.start).Seconds())
+	if data.Err != nil {
+		c.refreshFailures.WithLabelValues(r.cluster).Inc()
+	}
+}
+
+func (c *Collector) TraceLBHostSelectStart(ctx context.Context, data pgx.TraceLBHostSelectStartData) context.Context {
+	if start, ok := ctx.Value(connectKey).(*connectStart); ok {
+		start.cluster = data.ClusterName
+	}
+	return context.WithValue(ctx, selectClusterKey, data.ClusterName)
+}
+
+func (c *Collector) TraceLBHostSelectEnd(ctx context.Context, data pgx.TraceLBHostSelectEndData) {
+	if data.TopologyFallback {
+		cluster, _ := ctx.Value(selectClusterKey).(string)
+		c.fallbacks.WithLabelValues(cluster, FallbackTopology).Inc()
+	}
+}
+
+func (c *Collector) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (c *Collector) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
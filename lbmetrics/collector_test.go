@@ -0,0 +1,109 @@
+package lbmetrics_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/internal/ybmock"
+	"github.com/yugabyte/pgx/v5/lbmetrics"
+)
+
+// metricValue returns the value of the metric name with labels gathered from reg, 0 if there is none. The sample
+// count is returned for histograms.
+func metricValue(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue metrics
+				}
+			}
+			return value(m)
+		}
+	}
+	return 0
+}
+
+func value(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Histogram != nil:
+		return float64(m.Histogram.GetSampleCount())
+	}
+	return 0
+}
+
+func connect(t *testing.T, collector *lbmetrics.Collector, m *pgx.ClusterManager, connString string) *pgx.Conn {
+	config, err := pgx.ParseConfig(connString)
+	require.NoError(t, err)
+	config.Tracer = collector
+	config.ClusterManager = m
+
+	conn, err := pgx.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close(context.Background()) })
+	return conn
+}
+
+func TestCollectorExportsLoadBalancerMetrics(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b")
+	first, second := cluster.Nodes[0], cluster.Nodes[1]
+	cluster.Stop(second)
+
+	m := pgx.NewClusterManager()
+	defer m.Shutdown()
+	collector := lbmetrics.NewCollector(m)
+	defer collector.Close()
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(collector))
+
+	// The stopped server is tried by one of the connects, which is retried on the other one.
+	connString := cluster.ConnString("")
+	connect(t, collector, m, connString)
+	connect(t, collector, m, connString)
+
+	clusterLabel := map[string]string{"cluster": first.Host}
+	assert.EqualValues(t, 2, metricValue(t, reg, "pgx_lb_host_connections",
+		map[string]string{"cluster": first.Host, "host": first.Host, "node_type": "primary"}))
+	assert.EqualValues(t, 1, metricValue(t, reg, "pgx_lb_refresh_duration_seconds", clusterLabel))
+	assert.EqualValues(t, 0, metricValue(t, reg, "pgx_lb_refresh_failures_total", clusterLabel))
+	assert.EqualValues(t, 1, metricValue(t, reg, "pgx_lb_connect_retries_total", clusterLabel))
+	assert.Eventually(t, func() bool {
+		return metricValue(t, reg, "pgx_lb_host_marked_away_total",
+			map[string]string{"cluster": first.Host, "host": second.Host}) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCollectorCountsTopologyFallbacks(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	first := cluster.Nodes[0]
+
+	m := pgx.NewClusterManager()
+	defer m.Shutdown()
+	collector := lbmetrics.NewCollector(m)
+	defer collector.Close()
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(collector))
+
+	connect(t, collector, m, cluster.ConnString("topology_keys=aws.us-east-1.us-east-1a"))
+	fallbackLabels := map[string]string{"cluster": first.Host, "kind": lbmetrics.FallbackTopology}
+	assert.EqualValues(t, 0, metricValue(t, reg, "pgx_lb_fallbacks_total", fallbackLabels))
+
+	connect(t, collector, m, cluster.ConnString("topology_keys=aws.us-west-2.us-west-2a"))
+	assert.EqualValues(t, 1, metricValue(t, reg, "pgx_lb_fallbacks_total", fallbackLabels))
+}
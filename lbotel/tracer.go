@@ -0,0 +1,111 @@
+// Package lbotel traces the YugabyteDB load balancer with OpenTelemetry.
+//
+// It is a module of its own so that pgx itself does not depend on OpenTelemetry. It requires the release of pgx adding
+// the load balancer tracers, which must therefore be tagged before it, see CONTRIBUTING.md.
+package lbotel
+
+import (
+	"context"
+
+	"github.com/yugabyte/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/yugabyte/pgx/v5/lbotel"
+
+// Attribute keys set on the spans.
+const (
+	ClusterKey         = attribute.Key("yb.lb.cluster")
+	HostKey            = attribute.Key("yb.lb.host")
+	PortKey            = attribute.Key("yb.lb.port")
+	NodeTypeKey        = attribute.Key("yb.lb.node_type")
+	TopologyTierKey    = attribute.Key("yb.lb.topology_tier")
+	ControlHostKey     = attribute.Key("yb.lb.control_host")
+	ConnectAttemptsKey = attribute.Key("yb.lb.connect_attempts")
+	CandidatesKey      = attribute.Key("yb.lb.candidates")
+	ServersKey         = attribute.Key("yb.lb.servers")
+)
+
+// Tracer creates a span for every load balanced connect and, as its children, for the refreshes of the cluster's load
+// information and the selections of a server. It implements pgx.LBConnectTracer, pgx.LBRefreshTracer and
+// pgx.LBHostSelectTracer. It also implements pgx.QueryTracer, without tracing queries, so it can be assigned to
+// pgx.ConnConfig.Tracer.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a Tracer creating its spans with tp. The global TracerProvider is used if tp is nil.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: tp.Tracer(instrumentationName)}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *Tracer) TraceLBConnectStart(ctx context.Context, data pgx.TraceLBConnectStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "pgx.lb.connect", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(ClusterKey.String(data.ClusterName)))
+	return ctx
+}
+
+func (t *Tracer) TraceLBConnectEnd(ctx context.Context, data pgx.TraceLBConnectEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(ConnectAttemptsKey.Int(data.Attempts))
+	if data.Conn != nil {
+		config := data.Conn.Config()
+		span.SetAttributes(HostKey.String(config.Host), PortKey.Int(int(config.Port)))
+	}
+	endSpan(span, data.Err)
+}
+
+func (t *Tracer) TraceLBRefreshStart(ctx context.Context, data pgx.TraceLBRefreshStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "pgx.lb.refresh", trace.WithAttributes(ClusterKey.String(data.ClusterName)))
+	return ctx
+}
+
+func (t *Tracer) TraceLBRefreshEnd(ctx context.Context, data pgx.TraceLBRefreshEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(ControlHostKey.String(data.ControlHost))
+	if data.Err == nil {
+		span.SetAttributes(ServersKey.StringSlice(data.Servers))
+	}
+	endSpan(span, data.Err)
+}
+
+func (t *Tracer) TraceLBHostSelectStart(ctx context.Context, data pgx.TraceLBHostSelectStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "pgx.lb.select_host", trace.WithAttributes(ClusterKey.String(data.ClusterName)))
+	return ctx
+}
+
+func (t *Tracer) TraceLBHostSelectEnd(ctx context.Context, data pgx.TraceLBHostSelectEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Candidates != nil {
+		span.SetAttributes(CandidatesKey.StringSlice(data.Candidates))
+	}
+	if data.Err == nil {
+		span.SetAttributes(
+			HostKey.String(data.Host),
+			PortKey.Int(int(data.Port)),
+			NodeTypeKey.String(data.NodeType),
+			TopologyTierKey.Int(data.TopologyTier),
+		)
+	}
+	endSpan(span, data.Err)
+}
+
+func (t *Tracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (t *Tracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
@@ -0,0 +1,84 @@
+package lbotel_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/internal/ybmock"
+	"github.com/yugabyte/pgx/v5/lbotel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracerRecordsLoadBalancedConnect(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b")
+	first := cluster.Nodes[0]
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	config, err := pgx.ParseConfig(cluster.ConnString("topology_keys=aws.us-east-1.us-east-1b&cluster_name=east"))
+	require.NoError(t, err)
+	config.Tracer = lbotel.NewTracer(tp)
+
+	conn, err := pgx.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	refresh, selectHost, connect := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "pgx.lb.refresh", refresh.Name())
+	assert.Equal(t, connect.SpanContext().SpanID(), refresh.Parent().SpanID())
+	assert.Equal(t, "east", attributes(refresh)[lbotel.ClusterKey].AsString())
+	assert.Equal(t, first.Host, attributes(refresh)[lbotel.ControlHostKey].AsString())
+	assert.Equal(t, cluster.Hosts(), attributes(refresh)[lbotel.ServersKey].AsStringSlice())
+
+	assert.Equal(t, "pgx.lb.select_host", selectHost.Name())
+	assert.Equal(t, connect.SpanContext().SpanID(), selectHost.Parent().SpanID())
+	attrs := attributes(selectHost)
+	assert.Equal(t, "east", attrs[lbotel.ClusterKey].AsString())
+	assert.Equal(t, cluster.Nodes[1].Host, attrs[lbotel.HostKey].AsString())
+	assert.Equal(t, "primary", attrs[lbotel.NodeTypeKey].AsString())
+	assert.EqualValues(t, 0, attrs[lbotel.TopologyTierKey].AsInt64())
+	assert.Equal(t, []string{cluster.Nodes[1].Host}, attrs[lbotel.CandidatesKey].AsStringSlice())
+
+	assert.Equal(t, "pgx.lb.connect", connect.Name())
+	attrs = attributes(connect)
+	assert.Equal(t, "east", attrs[lbotel.ClusterKey].AsString(), "the connect span should be joinable to the others")
+	assert.Equal(t, cluster.Nodes[1].Host, attrs[lbotel.HostKey].AsString())
+	assert.EqualValues(t, 1, attrs[lbotel.ConnectAttemptsKey].AsInt64())
+}
+
+func TestTracerRecordsErrors(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	connString := cluster.ConnString("")
+	cluster.Stop(cluster.Nodes[0])
+
+	recorder := tracetest.NewSpanRecorder()
+	config, err := pgx.ParseConfig(connString)
+	require.NoError(t, err)
+	config.Tracer = lbotel.NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	_, err = pgx.ConnectConfig(context.Background(), config)
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.NotEmpty(t, spans)
+	connect := spans[len(spans)-1]
+	assert.Equal(t, "pgx.lb.connect", connect.Name())
+	assert.Equal(t, "Error", connect.Status().Code.String())
+	assert.NotEmpty(t, connect.Events(), "the error should be recorded")
+}
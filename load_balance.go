@@ -5,28 +5,92 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
-	"github.com/rs/zerolog/log"
-
+	"hash/fnv"
 	"maps"
 	"math"
 	"math/big"
+	mathrand "math/rand"
 	"net"
 	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/yugabyte/pgx/v5/pgconn"
 )
 
 const NO_SERVERS_MSG = "could not find a server to connect to"
 const MAX_RETRIES = 20
+const DEFAULT_COLD_START_BACKOFF_MS = 1000
 const REFRESH_INTERVAL_SECONDS = 300
 const DEFAULT_FAILED_HOST_RECONNECT_DELAY_SECS = 5
 const MAX_FAILED_HOST_RECONNECT_DELAY_SECS = 60
+
+// Fraction of its reconnect delay added at random to the time a host marked unavailable stays so, so that the servers
+// of a zone marked together are not all retried together when it recovers.
+const FAILED_HOST_RECONNECT_JITTER = 0.5
 const MAX_INTERVAL_SECONDS = 600
 const MAX_PREFERENCE_VALUE = 10
 const CONTROL_CONN_TIMEOUT = 15 * time.Second
 
+// Redacted by redactSecrets, in connection strings in the keyword/value and in the URL format.
+var sensitiveParams = regexp.MustCompile(`(?i)\b(password|sslpassword)=('(?:[^'\\]|\\.)*'|[^\s&\x60]*)`)
+var urlPassword = regexp.MustCompile(`(://[^:/@\s]*):[^@\s]*@`)
+
+// Time during which a selected server is considered to have warm caches, e.g. of prepared statements, for
+// config.affinityTolerance.
+const AFFINITY_WINDOW = 5 * time.Minute
+
+// Weight of the latest connect latency of a server in its moving average, for config.latencyAware.
+const LATENCY_EWMA_WEIGHT = 0.2
+
+// Ratio to the lowest average connect latency of the eligible servers up to which the average connect latency of a
+// server is low enough to select it, for config.latencyAware.
+const LATENCY_AWARE_TOLERANCE = 1.5
+
+// Maximum number of hosts kept in the unavailable hosts of a cluster. It is normally bounded by the number of servers
+// of the cluster already, but guards against churning topologies.
+const MAX_UNAVAILABLE_HOSTS = 1024
+
+// Number of consecutive failed connects to a server still listed by yb_servers() after which its reachability is
+// reported as asymmetric.
+const ASYMMETRIC_REACHABILITY_FAILURES = 3
+const DEFAULT_CIRCUIT_COOLDOWN_SECS = 30
+
+// Fraction of the time left before the deadline of a load balanced connect given to an attempt after which other
+// servers may be tried, unless load_balance_connect_attempt_timeout_ms is set.
+const CONNECT_ATTEMPT_DEADLINE_FRACTION = 0.5
+
+// Time during which the load balanced connects made with a ClusterManager connect without load balancing after the
+// load balancer panicked serving one of them, see ClusterManager.Degraded.
+const DEGRADED_MODE_DURATION = 30 * time.Second
+
 var ErrFallbackToOriginalBehaviour = errors.New("no preferred server available, fallback-to-topology-keys-only is set to true")
 
+// ErrNoServersAvailable is returned when load information is available but none of the servers can be used, e.g.
+// because all of them are marked as unavailable. The load balanced connects return it as a NoServersAvailableError.
+var ErrNoServersAvailable = errors.New(NO_SERVERS_MSG)
+
+// ErrTooFewEligibleHosts is returned by a load balanced connect when fewer servers than load_balance_min_eligible_hosts
+// could be selected.
+var ErrTooFewEligibleHosts = errors.New("fewer eligible servers than load_balance_min_eligible_hosts")
+
+// ErrConnectRateExceeded is returned by a load balanced connect exceeding load_balance_connect_rate when
+// load_balance_connect_rate_policy is "error".
+var ErrConnectRateExceeded = errors.New("load balanced connect rate of the cluster exceeded")
+
+// ErrLoadBalancerShutdown is returned by the load balanced connects waiting on the load balancer when
+// ShutdownLoadBalancer is called, and by the ones made afterwards.
+var ErrLoadBalancerShutdown = errors.New("load balancer shutting down")
+
+// ErrLoadBalancerPanicked is wrapped by the error of a load balanced connect whose request to the load balancer
+// panicked. The connect then falls back to the original behaviour, connecting to the hosts of its config.
+var ErrLoadBalancerPanicked = errors.New("load balancer panicked")
+
 // -- Values for ClusterLoadInfo.flags --
 // Use private address (host) of tservers to create a connection
 const USE_HOSTS byte = 0
@@ -40,13 +104,98 @@ const TRY_HOSTS_PUBLIC_IP byte = 2
 // Both the addresses (host, public_ip) of tserver to be tried, but no success with private addresses to create a connection
 const HOSTS_EXHAUSTED byte = 3
 
-// Indicate to the Go routine processing the requestChan that it should return a least loaded tserver host, port
+type addressTypeCtxKey struct{}
+
+// WithAddressType returns a copy of ctx that makes a load balanced connect with it use the given address type,
+// USE_HOSTS or USE_PUBLIC_IP, regardless of the address type detected for the cluster.
+func WithAddressType(ctx context.Context, addressType byte) context.Context {
+	return context.WithValue(ctx, addressTypeCtxKey{}, addressType)
+}
+
+type routingKeyCtxKey struct{}
+
+// WithRoutingKey returns a copy of ctx that makes a load balanced connect with it select the server key hashes to
+// among the eligible servers, rather than the least loaded one. Connects with the same key keep selecting the same
+// server, e.g. for the cache locality of a tenant, until it is marked unavailable or otherwise no longer eligible, in
+// which case the key moves to another server while the other keys stay where they are.
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKeyCtxKey{}, key)
+}
+
+// NodePreference is the type of server a load balanced connect with WithNodePreference prefers, regardless of the
+// load_balance setting of its config.
+type NodePreference int
+
+const (
+	// PreferPrimary selects a primary server, or a read replica if no primary is available, as
+	// load_balance=prefer-primary.
+	PreferPrimary NodePreference = iota + 1
+	// PreferReadReplica selects a read replica, or a primary if no read replica is available, as
+	// load_balance=prefer-rr.
+	PreferReadReplica
+)
+
+// NodeType returns the LBDecision.NodeType of the servers p prefers.
+func (p NodePreference) NodeType() string {
+	if p == PreferReadReplica {
+		return "read_replica"
+	}
+	return "primary"
+}
+
+func (p NodePreference) loadBalance() string {
+	if p == PreferReadReplica {
+		return "prefer-rr"
+	}
+	return "prefer-primary"
+}
+
+type nodePreferenceCtxKey struct{}
+
+// WithNodePreference returns a copy of ctx that makes a load balanced connect with it prefer the servers of pref. A
+// pgxpool.Pool acquiring a connection with it returns an idle connection to such a server if it has one, so that a
+// single pool can send reads to read replicas and writes to primaries.
+func WithNodePreference(ctx context.Context, pref NodePreference) context.Context {
+	return context.WithValue(ctx, nodePreferenceCtxKey{}, pref)
+}
+
+// NodePreferenceFromContext returns the preference ctx was given by WithNodePreference, if any.
+func NodePreferenceFromContext(ctx context.Context) (NodePreference, bool) {
+	pref, ok := ctx.Value(nodePreferenceCtxKey{}).(NodePreference)
+	return pref, ok
+}
+
+// Flags of the load information of a connect requesting the least loaded tserver host, port, before the address type
+// of its cluster is known
 const GET_LB_CONN byte = 4
 
-// Indicate to the Go routine processing the requestChan that it should decrease the connection count for the given host by one
+// Deprecated: connection counts are decremented by the ClusterManager directly, DECREMENT_COUNT is no longer used.
 const DECREMENT_COUNT byte = 5
 
+// NodeClass is the class of a server of the cluster as far as load balancing is concerned.
+type NodeClass int
+
+const (
+	// NodeClassPrimary servers are part of the primary cluster.
+	NodeClassPrimary NodeClass = iota
+	// NodeClassReadReplica servers are part of a read replica cluster.
+	NodeClassReadReplica
+	// NodeClassIgnore servers are never connected to.
+	NodeClassIgnore
+)
+
+func classifyNodeType(nodeType string) NodeClass {
+	if nodeType == "primary" {
+		return NodeClassPrimary
+	}
+	return NodeClassReadReplica
+}
+
+var ErrNoLoadInfo = errors.New("no load balancing information found for the cluster")
+
 type ClusterLoadInfo struct {
+	// held while the load information is read or modified, see ClusterManager
+	mu          sync.Mutex
 	clusterName string
 	ctx         context.Context
 	config      *ConnConfig
@@ -68,410 +217,2270 @@ type ClusterLoadInfo struct {
 	// map of (private -> public) address of a node.
 	hostPairs map[string]string
 	flags     byte
+	// ring buffer of the most recently selected hosts, holds at most config.selectionWindow entries
+	recentSelections     []string
+	recentSelectionsNext int
+	// map of host -> number of times it was selected since the cluster was created or ResetSelectionCounts
+	selectionCounts map[string]int
+	// map of host -> sample of its successful connect latencies
+	connectLatencies map[string]*latencyReservoir
+	// map of host -> exponentially weighted moving average of its successful connect latencies
+	latencyAverages map[string]time.Duration
+	// map of host -> time until which connections to it are discarded, even if they succeed
+	quarantinedUntil map[string]time.Time
+	// map of host -> consecutive failed data connects to it while the control connection listed it
+	dataConnectFailures map[string]int
+	// map of host -> number of times in a row it was marked unavailable, reset by a successful connect to it
+	awayCounts map[string]int
+	// map of host marked unavailable -> seconds after which it is available again, see reconnectDelaySecs
+	reconnectDelays map[string]int64
+	// map of host -> time it was found reachable by the control connection but not by data connects
+	asymmetricHosts map[string]time.Time
+	// map of private host -> time it was last selected
+	lastSelected map[string]time.Time
+	// map of private host -> time it joined the cluster, for the hosts added after the first refresh, see
+	// config.slowStart
+	joinedAt map[string]time.Time
+	// number of rows of yb_servers() skipped because they could not be read, see config.skipBadRows
+	skippedRows uint64
+	// map of host -> replication lag in milliseconds, for the servers reporting it
+	replicationLag map[string]int64
+	// time of the last refresh which updated the servers, unlike lastRefresh it is never set otherwise
+	lastSuccessfulRefresh time.Time
+	// time the control connection moved to config.controlHost
+	controlHostSince time.Time
+	// set by PauseRefresh, the servers are not refreshed until ResumeRefresh
+	refreshPaused bool
+	// incremented by every refresh changing the set of hosts
+	generation uint64
+	// phase durations collected by the refresh creating the cluster's load information, nil otherwise
+	coldStart *ColdStartTiming
+	// phase durations of the first load balanced connect to the cluster
+	lastColdStart ColdStartTiming
+	// ID of the request, set by requestHost when the request is served on a Go routine of its own
+	requestID uint64
+	// map of host marked unavailable -> its probing schedule, see config.probeInterval
+	probes map[string]*hostProbe
+	// 1 while the hosts marked unavailable are probed, accessed atomically
+	probing int32
+	// map of host excluded by MarkHostUnavailable -> time until which it is, zero until UnmarkHost
+	drainedHosts map[string]time.Time
+	// control connections to other servers than the control host, which replace controlConn if it fails
+	standbyControlConns []*Conn
+	// number of times a standby control connection replaced controlConn
+	controlFailovers uint64
+	// map of host -> UUID_COLUMN of the server, for the servers reporting it
+	hostUUIDs map[string]string
+	// map of former address of a server -> its current address, see renameHost
+	renamedHosts map[string]string
+	// set once yb_servers() was found to have no UUID_COLUMN, LB_QUERY_WITHOUT_UUID is run from then on
+	noUUIDColumn bool
+	// level of the fallback ladder the latest server was selected from
+	fallbackLevel FallbackLevel
+	// closed once the latest call to config.OnTopologyChange returned, nil if there was none
+	topologyNotified chan struct{}
+	// map of table -> its tablets with the host of their leaders, for config.tabletAware
+	tabletLeaders map[string][]tabletRange
+	// map of shard key, see shardKeyString -> its yb_hash_code(), for config.tabletAware
+	shardHashCodes map[string]int
+	// set once the server was found to have no TABLET_LEADERS_QUERY table, tablet leaders are not queried from then on
+	noTabletMetadata bool
 }
 
 type lbHost struct {
 	hostname string
 	port     uint16
-	err      error
+	// private address of the host, which its connection count is kept against
+	countedHost string
+	// "primary" or "read_replica"
+	nodeType string
+	// "cloud.region.zone" of the host
+	placement string
+	// host of the control connection of the cluster at the time of the selection
+	controlHost string
+	// index of the topology_keys preference the host was selected by, -1 if not selected by topology_keys
+	topologyTier int
+	// level of the fallback ladder the server was selected from
+	fallbackLevel FallbackLevel
+	// generation of the cluster's load information the host was selected from
+	generation uint64
+	// phase durations of the refresh that created the cluster's load information, nil for known clusters
+	coldStart *ColdStartTiming
+	// whether err is the failure of the refresh that was to create the cluster's load information
+	coldStartFailed bool
+	// ID of the request this is the reply to
+	requestID uint64
+	err       error
+}
+
+// LBDecision summarizes how the server of a load balanced connection was chosen.
+type LBDecision struct {
+	// Host and Port are the address connected to.
+	Host string
+	Port uint16
+	// NodeType is "primary" or "read_replica", empty if the connection was not load balanced.
+	NodeType string
+	// Placement is the "cloud.region.zone" of the server, empty if the connection was not load balanced.
+	Placement string
+	// TopologyTier is the preference value of the topology_keys the server was selected by minus one, so 0 for the
+	// keys without a preference value, -1 if it was not selected by topology_keys.
+	TopologyTier int
+	// FallbackLevel is the level of the fallback ladder the server was selected from if no server matching
+	// topology_keys was available, FallbackLevelNone otherwise.
+	FallbackLevel FallbackLevel
+	// AddressType is "private" or "public", depending on the address of the server connected to.
+	AddressType string
+	// Attempts is the number of servers connected to until the connection succeeded.
+	Attempts int
+	// SharesControlHost is true if the server is also the one the control connection of the cluster is made to.
+	SharesControlHost bool
+	// InternalFallbacks is the number of hosts other than the selected servers tried by the attempts, as the Fallbacks
+	// of the connection string or other addresses of a server. It is always 0 with load_balance_disable_fallbacks.
+	InternalFallbacks int
+	// Fallback is true if the connection was made to the host of the connection string without load balancing, e.g.
+	// because the servers of the cluster could not be fetched.
+	Fallback bool
+}
+
+func (h *lbHost) decision() *LBDecision {
+	d := &LBDecision{
+		Host:          h.hostname,
+		Port:          h.port,
+		NodeType:      h.nodeType,
+		Placement:     h.placement,
+		TopologyTier:  h.topologyTier,
+		FallbackLevel: h.fallbackLevel,
+		AddressType:   "private",
+		// The control host is the private or the public address of a server, as any host of the connection string.
+		SharesControlHost: h.controlHost == h.hostname || h.controlHost == h.countedHost,
+	}
+	if h.hostname != h.countedHost {
+		d.AddressType = "public"
+	}
+	return d
 }
 
-var clustersLoadInfo map[string]*ClusterLoadInfo
+const LB_QUERY = "SELECT host,port,num_connections,node_type,cloud,region,zone,public_ip,uuid FROM yb_servers()"
+
+// LB_QUERY_WITH_LAG is run instead of LB_QUERY when config.maxReplicaLagMs is set, so that a REPLICATION_LAG_COLUMN
+// is returned if the servers report it.
+const LB_QUERY_WITH_LAG = "SELECT * FROM yb_servers()"
+
+// REPLICATION_LAG_COLUMN is the optional column of yb_servers() holding the replication lag of a server in
+// milliseconds. Read replicas lagging more than load_balance_max_replica_lag_ms are not selected.
+const REPLICATION_LAG_COLUMN = "replication_lag_ms"
+
+// ClusterManager owns the load information of the clusters load balanced connections are made to. The load information
+// of every cluster has its own lock, so that a refresh, which queries the servers of its cluster, only holds up the
+// connects to that cluster. Connections share the default ClusterManager, which the package-level functions like
+// DumpLoadBalancerState operate on, unless ConnConfig.ClusterManager is set. A separate ClusterManager, e.g. for each
+// pgxpool.Pool, keeps the load information of its connections, and the policies it is refreshed with, apart from the
+// other connections to the same cluster.
+type ClusterManager struct {
+	// the counters of the connects load balanced with m, accessed atomically. They come first so that they are 64-bit
+	// aligned on 32-bit platforms.
+	directConnects              DirectConnectStats
+	topologyKeysStrictFallbacks uint64
+	// guards clusters, creating and stopped. It is never held while waiting for the lock of a cluster, so that taking
+	// the locks of several clusters, which inspectLoadInfo does before taking it, cannot deadlock.
+	mu       sync.RWMutex
+	clusters map[string]*ClusterLoadInfo
+	// map of cluster name -> channel closed once the refresh creating the load information of the cluster completed
+	creating map[string]chan struct{}
+	// set by Shutdown, no request is served afterwards
+	stopped bool
+	// the requests being served, which Shutdown waits for
+	requests sync.WaitGroup
+	// closed by Shutdown so that the requests waiting for their reply fail
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+	// the Go routines probing the hosts marked unavailable, which Shutdown waits for
+	probers sync.WaitGroup
+	// time until which the connects are not load balanced since a request panicked, guarded by mu
+	degradedUntil time.Time
+	circuits      lbCircuits
+	rateLimiters  connectRateLimiters
+	events        lbEventSubscribers
+}
 
-const LB_QUERY = "SELECT host,port,num_connections,node_type,cloud,region,zone,public_ip FROM yb_servers()"
+// defaultClusterManager is the ClusterManager of the connections whose ConnConfig.ClusterManager is nil.
+var defaultClusterManager *ClusterManager
 
-// Only the Go routine spawned in init() reads this channel. Based on the flag, it
-// - returns the least loaded tserver's host/port (GET_LB_CONN)
-// - decrements connection count by one for closed connection (DECREMENT_COUNT)
-var requestChan chan *ClusterLoadInfo
+// NewClusterManager returns a ClusterManager without load information. It serves the connections configured with it
+// until Shutdown is called.
+func NewClusterManager() *ClusterManager {
+	return &ClusterManager{
+		clusters:     make(map[string]*ClusterLoadInfo),
+		creating:     make(map[string]chan struct{}),
+		shutdown:     make(chan struct{}),
+		circuits:     lbCircuits{m: make(map[string]*lbCircuit)},
+		rateLimiters: connectRateLimiters{m: make(map[string]*connectRateLimiter)},
+	}
+}
 
-// Only the Go routine spawned in init() writes to this channel.
-// It returns the least loaded tserver's host/port if successful else err
-var hostChan chan *lbHost
+// clusterManager returns the ClusterManager load balanced connections with config are made with.
+func (cc *ConnConfig) clusterManager() *ClusterManager {
+	if cc.ClusterManager != nil {
+		return cc.ClusterManager
+	}
+	return defaultClusterManager
+}
 
 func NewClusterLoadInfo(ctx context.Context, config *ConnConfig) *ClusterLoadInfo {
+	return newClusterLoadInfo(ctx, config, config.ClusterName())
+}
+
+// newClusterLoadInfo is NewClusterLoadInfo for a config whose cluster name has already been resolved.
+func newClusterLoadInfo(ctx context.Context, config *ConnConfig, clusterName string) *ClusterLoadInfo {
 	info := new(ClusterLoadInfo)
-	info.clusterName = LookupIP(config.Host)
+	info.clusterName = clusterName
 	info.ctx = ctx
 	info.config = config
 	info.flags = GET_LB_CONN
 	return info
 }
 
+// ClusterName returns the name the load information of the cluster of cc is kept under, and which identifies the
+// cluster to functions like RefreshClusterInfo: its cluster_name if set, the address of its Host otherwise. Setting
+// cluster_name keeps apart clusters reached through the same address, e.g. the virtual IP of a load balancer in front
+// of them, and lets connection strings naming different servers of a cluster share its load information.
+func (cc *ConnConfig) ClusterName() string {
+	if cc.clusterName != "" {
+		return cc.clusterName
+	}
+	return LookupIP(cc.Host)
+}
+
+// lookupHost resolves host names for LookupIP, it is replaced by tests.
+var lookupHost = net.LookupHost
+
+// LookupIP returns the address servers are tracked under for host. A host is resolved, following CNAMEs, to the first
+// in lexical order of its IPv4 addresses, or of its IPv6 ones if it has none, in canonical form. This way a server
+// keeps the same address whatever name it is reached by and whatever order the resolver returns its addresses in. host
+// is returned as is if it cannot be resolved.
 func LookupIP(host string) string {
-	addrs, err := net.LookupHost(host)
-	if err == nil {
-		for _, addr := range addrs {
-			if strings.Contains(addr, ".") {
-				return addr
-			}
+	if isUnixSocket(host) {
+		return host
+	}
+	addrs, err := lookupHost(host)
+	if err != nil {
+		return host
+	}
+	var v4, v6 []string
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip == nil {
+			v6 = append(v6, addr)
+		} else if ip.To4() != nil {
+			v4 = append(v4, ip.String())
+		} else {
+			v6 = append(v6, ip.String())
 		}
-		if len(addrs) > 0 {
-			return addrs[0]
+	}
+	for _, candidates := range [][]string{v4, v6} {
+		if len(candidates) > 0 {
+			sort.Strings(candidates)
+			return candidates[0]
 		}
 	}
 	return host
 }
 
 func init() {
-	clustersLoadInfo = make(map[string]*ClusterLoadInfo)
-	requestChan = make(chan *ClusterLoadInfo)
-	hostChan = make(chan *lbHost)
-	go produceHostName(requestChan, hostChan)
-}
-
-func replaceHostString(connString string, newHost string, port uint16) string {
-	newConnString := connString
-	if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
-		if strings.Contains(connString, "@") {
-			pattern := regexp.MustCompile("@([^/]*)/")
-			// todo IPv6 handling
-			newConnString = pattern.ReplaceAllString(connString, fmt.Sprintf("@%s:%d/", newHost, port))
-		} else {
-			pattern := regexp.MustCompile("://([^/]*)/")
-			newConnString = pattern.ReplaceAllString(connString, fmt.Sprintf("://%s:%d/", newHost, port))
+	defaultClusterManager = NewClusterManager()
+}
+
+// ShutdownLoadBalancer shuts the default ClusterManager down, see ClusterManager.Shutdown.
+func ShutdownLoadBalancer() {
+	defaultClusterManager.Shutdown()
+}
+
+// Shutdown stops load balancing with m and closes the control connections of its clusters. The load balanced connects
+// waiting for a server, and the ones made afterwards, fail with ErrLoadBalancerShutdown. It returns once the control
+// connections are closed, which may wait for a refresh in progress to complete.
+func (m *ClusterManager) Shutdown() {
+	m.shutdownOnce.Do(func() {
+		m.mu.Lock()
+		m.stopped = true
+		close(m.shutdown)
+		m.mu.Unlock()
+		m.requests.Wait()
+		m.probers.Wait()
+		m.closeControlConns()
+	})
+}
+
+// closeControlConns closes the control connections of all clusters.
+func (m *ClusterManager) closeControlConns() {
+	for _, li := range m.sortedClusters() {
+		li.mu.Lock()
+		closeControlConn(li.controlConn)
+		li.controlConn = nil
+		for _, standby := range li.standbyControlConns {
+			closeControlConn(standby)
 		}
-	} else { // key = value (DSN style)
-		pattern := regexp.MustCompile("host=([^ ]*) ")
-		newConnString = pattern.ReplaceAllString(connString, fmt.Sprintf("host=%s ", newHost))
-		pattern = regexp.MustCompile("port=([^ ]*) ")
-		newConnString = pattern.ReplaceAllString(newConnString, fmt.Sprintf("port=%d ", port))
+		li.standbyControlConns = nil
+		li.mu.Unlock()
 	}
-	return newConnString
 }
 
-func produceHostName(in chan *ClusterLoadInfo, out chan *lbHost) {
+// sortedClusters returns the load information of the clusters of m, ordered by cluster name. The locks of several
+// clusters are taken in this order.
+func (m *ClusterManager) sortedClusters() []*ClusterLoadInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	clis := make([]*ClusterLoadInfo, 0, len(m.clusters))
+	for _, li := range m.clusters {
+		clis = append(clis, li)
+	}
+	sort.Slice(clis, func(i, j int) bool { return clis[i].clusterName < clis[j].clusterName })
+	return clis
+}
 
+// serve returns the least loaded server of the cluster of new, creating the load information of the cluster if there
+// is none yet. The first connects to a cluster wait for the refresh creating its load information, the connects to the
+// other clusters go on meanwhile.
+func (m *ClusterManager) serve(new *ClusterLoadInfo) *lbHost {
 	for {
-		new, present := <-in
-
-		if !present {
-			log.Warn().Msg("The requestChannel is closed, load_balance feature will not work")
-			break
+		m.mu.RLock()
+		old, present := m.clusters[new.clusterName]
+		m.mu.RUnlock()
+		if present {
+			return serveKnown(old, new)
 		}
-		if new.flags == DECREMENT_COUNT {
-			names := strings.Split(new.clusterName, ",")
-			if len(names) != 2 {
-				log.Warn().Msgf("cannot parse names to update connection count: %s", new.clusterName)
-			} else {
-				cli, ok := clustersLoadInfo[LookupIP(names[0])]
-				if ok {
-					cnt, found := cli.hostLoadPrimary[names[1]]
-					if found {
-						if cnt != 0 {
-							cli.hostLoadPrimary[names[1]] = cnt - 1
-						}
-					} else if cnt, found = cli.hostLoadRR[names[1]]; found {
-						if cnt != 0 {
-							cli.hostLoadRR[names[1]] = cnt - 1
-						}
-					}
-				}
-			}
+		m.mu.Lock()
+		if old, present = m.clusters[new.clusterName]; present {
+			m.mu.Unlock()
+			return serveKnown(old, new)
+		}
+		if done, ok := m.creating[new.clusterName]; ok {
+			// Another connect is creating the load information, use it once it is there or try again if it failed.
+			m.mu.Unlock()
+			<-done
 			continue
 		}
-		old, present := clustersLoadInfo[new.clusterName]
-		if !present {
-			// There is no loadInfo available for this config. Create one.
-			err := refreshLoadInfo(new)
-			if err != nil {
-				lb := &lbHost{
-					hostname: "",
-					err:      err,
-				}
-				out <- lb
-				continue
-			}
-			publicIpAvailable := false
-			for k, v := range new.hostPairs {
-				if v != "" {
-					publicIpAvailable = true
-				}
-				if new.clusterName == k {
-					new.flags = USE_HOSTS
-					break
-				} else if new.clusterName == v {
-					new.flags = USE_PUBLIC_IP
-					break
-				} else {
-					new.flags = TRY_HOSTS_PUBLIC_IP
-				}
-			}
-			if !publicIpAvailable {
-				new.flags = USE_HOSTS
-			}
+		done := make(chan struct{})
+		m.creating[new.clusterName] = done
+		m.mu.Unlock()
 
-			clustersLoadInfo[new.clusterName] = new
+		// Deferred so that the connects waiting on done are released even if create panics.
+		defer func() {
+			m.mu.Lock()
+			delete(m.creating, new.clusterName)
+			m.mu.Unlock()
+			close(done)
+		}()
+		return m.create(new)
+	}
+}
 
-			out <- getHostWithLeastConns(new)
-			// continue
+// create refreshes the load information of a cluster m has none of yet and, if it succeeds, adds it to the clusters
+// of m. new is not shared until then, so it is not locked.
+func (m *ClusterManager) create(new *ClusterLoadInfo) *lbHost {
+	// There is no loadInfo available for this config. Create one.
+	// It keeps its own copy of the config since the caller modifies its config to connect to the selected host.
+	new.config = new.config.Copy()
+	new.coldStart = &ColdStartTiming{}
+	emitLBEvent(new.config, LBEvent{Type: LBEventClusterCreated, ClusterName: new.clusterName, Flags: new.flags})
+	err := refreshLoadInfo(new)
+	coldStart := new.coldStart
+	new.coldStart = nil
+	if err != nil {
+		emitLBEvent(new.config, LBEvent{Type: LBEventClusterEvicted, ClusterName: new.clusterName})
+		return &lbHost{
+			hostname:        "",
+			err:             err,
+			coldStartFailed: true,
+		}
+	}
+	publicIpAvailable := false
+	host := new.clusterName
+	if new.config.clusterName != "" {
+		host = LookupIP(new.config.Host)
+	}
+	for k, v := range new.hostPairs {
+		if v != "" {
+			publicIpAvailable = true
+		}
+		if host == k {
+			new.flags = USE_HOSTS
+			break
+		} else if host == v {
+			new.flags = USE_PUBLIC_IP
+			break
 		} else {
-			old.config.topologyKeys = new.config.topologyKeys // Use the provided topology-keys.
-			old.config.fallbackToTopologyKeysOnly = new.config.fallbackToTopologyKeysOnly
-			old.config.failedHostReconnectDelaySecs = new.config.failedHostReconnectDelaySecs
-			old.config.loadBalance = new.config.loadBalance
-			old.config.connString = new.config.connString
-			out <- refreshAndGetLeastLoadedHost(old, new.unavailableHosts)
-			// continue
+			new.flags = TRY_HOSTS_PUBLIC_IP
 		}
 	}
+	if !publicIpAvailable {
+		new.flags = USE_HOSTS
+	}
+	emitLBEvent(new.config, LBEvent{Type: LBEventFlagsChanged, ClusterName: new.clusterName, Flags: new.flags})
+
+	lbh := getHostWithLeastConns(new)
+	lbh.coldStart = coldStart
+	m.mu.Lock()
+	m.clusters[new.clusterName] = new
+	m.mu.Unlock()
+	return lbh
+}
+
+// serveKnown returns the least loaded server of the cluster of old, whose load information is refreshed with the
+// config of the connect new if it is due.
+func serveKnown(old *ClusterLoadInfo, new *ClusterLoadInfo) *lbHost {
+	old.mu.Lock()
+	return serveLocked(old, new)
+}
+
+// serveLocked is serveKnown with old already locked, it unlocks it.
+func serveLocked(old *ClusterLoadInfo, new *ClusterLoadInfo) *lbHost {
+	defer old.mu.Unlock()
+	applyRequestConfig(old.config, new.config)
+	old.ctx = new.ctx
+	return refreshAndGetLeastLoadedHost(old, new.unavailableHosts)
+}
+
+// applyRequestConfig sets the load balancing settings of config, the config of a cluster's load information, to the
+// ones of the request's config. A cluster is refreshed and its servers selected with the settings of the latest
+// request.
+func applyRequestConfig(config *ConnConfig, request *ConnConfig) {
+	config.topologyKeys = request.topologyKeys // Use the provided topology-keys.
+	config.fallbackToTopologyKeysOnly = request.fallbackToTopologyKeysOnly
+	config.fallbackLadder = request.fallbackLadder
+	config.slowStart = request.slowStart
+	config.slowStartTierFactor = request.slowStartTierFactor
+	config.failedHostReconnectDelaySecs = request.failedHostReconnectDelaySecs
+	config.loadBalance = request.loadBalance
+	config.connString = request.connString
+	config.Tracer = request.Tracer
+	config.BeforeControlQuery = request.BeforeControlQuery
+	config.ClassifyNodeType = request.ClassifyNodeType
+	config.OnTopologyChange = request.OnTopologyChange
+	config.LBLogger = request.LBLogger
+	config.selectionWindow = request.selectionWindow
+	config.quarantineSecs = request.quarantineSecs
+	config.countDecayFraction = request.countDecayFraction
+	config.controlDatabase = request.controlDatabase
+	config.allowHosts = request.allowHosts
+	config.priorityHosts = request.priorityHosts
+	config.minEligibleHosts = request.minEligibleHosts
+	config.affinityTolerance = request.affinityTolerance
+	config.intraTierOrdered = request.intraTierOrdered
+	config.latencyAware = request.latencyAware
+	config.tabletAware = request.tabletAware
+	config.maxReplicaLagMs = request.maxReplicaLagMs
+	config.skipBadRows = request.skipBadRows
+	config.localAddresses = request.localAddresses
+	config.serversQuery = request.serversQuery
+	config.serverLoad = request.serverLoad
+	config.maxConnsPerHost = request.maxConnsPerHost
+	config.probeInterval = request.probeInterval
+	config.controlConns = request.controlConns
+}
+
+// ConnectFunc connects to the Host, Port and Fallbacks of config, without load balancing.
+type ConnectFunc func(ctx context.Context, config *ConnConfig) (*Conn, error)
+
+// LoadBalancer makes the connections of configs with load_balance set. Implementations other than
+// DefaultLoadBalancer are meant for tests, e.g. to connect to a fixed server without querying the servers of a
+// cluster.
+type LoadBalancer interface {
+	// Connect establishes a connection for config. It may modify config, which is a copy, and pass it to connect to
+	// connect to the server it selected.
+	Connect(ctx context.Context, config *ConnConfig, connect ConnectFunc) (*Conn, error)
+}
+
+type clusterLoadBalancer struct{}
+
+func (clusterLoadBalancer) Connect(ctx context.Context, config *ConnConfig, _ ConnectFunc) (*Conn, error) {
+	return connectLoadBalanced(ctx, config)
+}
+
+// DefaultLoadBalancer selects the least loaded server of the cluster as reported by yb_servers(), according to the
+// load balancing settings of the config.
+var DefaultLoadBalancer LoadBalancer = clusterLoadBalancer{}
+
+func connectWithLoadBalancer(ctx context.Context, config *ConnConfig) (*Conn, error) {
+	if config.LoadBalancer != nil {
+		return config.LoadBalancer.Connect(ctx, config, connect)
+	}
+	return DefaultLoadBalancer.Connect(ctx, config, connect)
 }
 
 func connectLoadBalanced(ctx context.Context, config *ConnConfig) (c *Conn, err error) {
-	newLoadInfo := NewClusterLoadInfo(ctx, config)
-	requestChan <- newLoadInfo
-	leastLoadedHost := <-hostChan
+	if err := validateLBConfig(config); err != nil {
+		return nil, err
+	}
+	attempts := 0
+	lbConfig := config.Copy()
+	start := time.Now()
+	clusterName := config.ClusterName()
+	dns := time.Since(start)
+	if tracer, ok := config.Tracer.(LBConnectTracer); ok {
+		ctx = tracer.TraceLBConnectStart(ctx, TraceLBConnectStartData{ConnConfig: config, ClusterName: clusterName})
+		defer func() {
+			tracer.TraceLBConnectEnd(ctx, TraceLBConnectEndData{Conn: c, Attempts: attempts, Err: err})
+		}()
+	}
+	defer func() {
+		if c != nil {
+			c.connectAttempts = attempts
+			if c.lbDecision == nil {
+				c.lbDecision = &LBDecision{Host: c.config.Host, Port: c.config.Port, TopologyTier: -1, Fallback: true}
+			}
+			c.lbDecision.Attempts = attempts
+			c.lbConfig = lbConfig
+		}
+	}()
+
+	if config.lastResortOriginalHost {
+		originalConfig := config.Copy()
+		defer func() {
+			if errors.Is(err, ErrNoServersAvailable) {
+				lbLogf(config, LBLogLevelWarn, "No server of the cluster could be connected to, trying %s as last resort",
+					originalConfig.Host)
+				attempts++
+				c, err = connectUncounted(ctx, originalConfig)
+			}
+		}()
+	}
+
+	if config.autoTopology && config.topologyKeys == nil {
+		config.topologyKeys = autoTopologyKeys(ctx, config)
+	}
+	newLoadInfo := newClusterLoadInfo(ctx, config, clusterName)
+	m := config.clusterManager()
+	if config.circuitFailures > 0 && m.isCircuitOpen(newLoadInfo.clusterName) {
+		attempts = 1
+		atomic.AddUint64(&m.directConnects.CircuitOpen, 1)
+		return connectUncounted(ctx, config) // load balancing is disabled for the cluster until the circuit closes
+	}
+	if m.Degraded() {
+		attempts = 1
+		atomic.AddUint64(&m.directConnects.Degraded, 1)
+		return connectUncounted(ctx, config) // load balancing is disabled until the load balancer recovered from a panic
+	}
+	if config.connectRate > 0 {
+		if err := m.waitConnectRate(ctx, newLoadInfo.clusterName, config); err != nil {
+			return nil, err
+		}
+	}
+	leastLoadedHost := m.requestHost(newLoadInfo)
+	for i := 0; i < config.coldStartRetries && leastLoadedHost.coldStartFailed; i++ {
+		lbLogf(config, LBLogLevelWarn, "Could not load the servers of cluster %s, retrying in %s: %s",
+			newLoadInfo.clusterName, config.coldStartBackoff, redactSecrets(leastLoadedHost.err.Error()))
+		if err := sleepContext(ctx, config.coldStartBackoff); err != nil {
+			return nil, err
+		}
+		newLoadInfo = NewClusterLoadInfo(ctx, config)
+		leastLoadedHost = m.requestHost(newLoadInfo)
+	}
+	if config.probeInterval > 0 {
+		m.startProbing(newLoadInfo.clusterName)
+	}
+	if config.circuitFailures > 0 {
+		m.recordLoadBalancerResult(newLoadInfo.clusterName, config, leastLoadedHost.err)
+	}
+	if coldStart := leastLoadedHost.coldStart; coldStart != nil {
+		coldStart.DNS = dns
+		dataStart := time.Now()
+		defer func() {
+			coldStart.DataConnect = time.Since(dataStart)
+			coldStart.Total = time.Since(start)
+			recordColdStart(m, newLoadInfo.clusterName, *coldStart)
+		}()
+	}
 	if leastLoadedHost.err == ErrFallbackToOriginalBehaviour {
+		if config.OnTopologyKeysStrictFallback != nil {
+			config.OnTopologyKeysStrictFallback(newLoadInfo.clusterName)
+		}
+		return nil, leastLoadedHost.err
+	}
+	if errors.Is(leastLoadedHost.err, ErrNoServersAvailable) {
+		// The cluster is known but none of its servers is reachable, the original host would most likely fail too.
+		return nil, leastLoadedHost.err
+	}
+	if leastLoadedHost.err == ErrTooFewEligibleHosts || leastLoadedHost.err == ErrLoadBalancerShutdown {
 		return nil, leastLoadedHost.err
 	}
 	if leastLoadedHost.err != nil {
-		return connect(ctx, config) // fallback to original behaviour
+		attempts = 1
+		atomic.AddUint64(&m.directConnects.NoLoadInfo, 1)
+		return connectUncounted(ctx, config) // load information unavailable, fallback to original behaviour
 	}
 	if leastLoadedHost.hostname == config.Host {
 		/*
 			Discarding rest of the fallback option to handle multi host urls,
 			since we want to fallback to the next least loaded server and not the next host of the url.
 		*/
+		config = config.Copy()
 		if len(config.Fallbacks) > 0 {
 			config.Fallbacks = config.Fallbacks[:1]
+			if isUnixSocket(config.Fallbacks[0].Host) {
+				config.Fallbacks = nil // a unix socket is not a server the load balancer selects
+			}
 		}
-		config.connString = replaceHostString(config.connString, leastLoadedHost.hostname, leastLoadedHost.port)
-		return connectWithRetries(ctx, config.controlHost, config, newLoadInfo, leastLoadedHost)
 	} else {
-		newConnString := replaceHostString(config.connString, leastLoadedHost.hostname, leastLoadedHost.port)
-		newConfig, err := ParseConfig(newConnString)
-		if err != nil {
-			return nil, err
-		}
-		/*
-			Replacing Host, port, Fallbacks list and connstring in the user config,
-			as per the least loaded server received.
-		*/
-		config.Host = newConfig.Host
-		config.Port = newConfig.Port
-		config.Fallbacks = newConfig.Fallbacks
-		config.connString = newConfig.connString
-		return connectWithRetries(ctx, config.controlHost, config, newLoadInfo, leastLoadedHost)
+		config = config.cloneWithHost(leastLoadedHost.hostname, leastLoadedHost.port)
 	}
+	config.countedHost = leastLoadedHost.countedHost
+	c, attempts, err = connectWithRetries(ctx, config, newLoadInfo, leastLoadedHost)
+	return c, err
 }
 
-func connectWithRetries(ctx context.Context, controlHost string, config *ConnConfig,
-	newLoadInfo *ClusterLoadInfo, leastLoadedHost *lbHost) (c *Conn, er error) {
-	var timeout time.Duration = 0
-	if ctxDeadline, ok := ctx.Deadline(); ok {
-		timeout = time.Until(ctxDeadline)
+// connectUncounted connects to the host of config without load balancing. The connection is not counted against any
+// server, so there is nothing to decrement on close.
+func connectUncounted(ctx context.Context, config *ConnConfig) (*Conn, error) {
+	c, err := connect(ctx, config)
+	if err == nil {
+		c.closeCntUpdated = true
 	}
-	conn, err := connect(ctx, config)
-	for i := 0; i < MAX_RETRIES && err != nil; i++ {
-		decrementConnCount(config.controlHost + "," + config.Host)
-		log.Warn().Msgf("Adding %s to unavailableHosts due to %s", config.Host, err.Error())
-		newLoadInfo.unavailableHosts = map[string]int64{leastLoadedHost.hostname: time.Now().Unix()}
-		requestChan <- newLoadInfo
-		leastLoadedHost = <-hostChan
-		if leastLoadedHost.err != nil {
-			return nil, leastLoadedHost.err
+	return c, err
+}
+
+// ConnectMultiCluster connects to the cluster of primaryConnString and, only if none of its servers can be reached,
+// to the cluster of standbyConnString. Each connection string is handled like it would be by Connect, so with
+// load_balance set the connection is balanced within the chosen cluster. The errors of a server of the primary cluster
+// refusing the connection, e.g. because of a wrong password or database, are returned as is. If the standby cluster
+// cannot be connected to either, a *MultiClusterError holding both errors is returned.
+func ConnectMultiCluster(ctx context.Context, primaryConnString, standbyConnString string) (*Conn, error) {
+	primaryConfig, err := ParseConfig(primaryConnString)
+	if err != nil {
+		return nil, err
+	}
+	standbyConfig, err := ParseConfig(standbyConnString)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ConnectConfig(ctx, primaryConfig)
+	if err == nil || ctx.Err() != nil || !isClusterUnreachable(err) {
+		return conn, err
+	}
+	lbLogf(primaryConfig, LBLogLevelWarn,
+		"Could not connect to primary cluster %s, failing over to standby cluster %s: %s",
+		primaryConfig.Host, standbyConfig.Host, redactSecrets(err.Error()))
+	conn, standbyErr := ConnectConfig(ctx, standbyConfig)
+	if standbyErr != nil {
+		return nil, &MultiClusterError{Primary: err, Standby: standbyErr}
+	}
+	return conn, nil
+}
+
+// isClusterUnreachable reports whether err, returned by a connect, means that no server of the cluster could be
+// reached, e.g. because none could be dialed or none is available to the load balancer, rather than that a server
+// answered and refused the connection.
+func isClusterUnreachable(err error) bool {
+	if errors.Is(err, ErrNoServersAvailable) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func connectWithRetries(ctx context.Context, config *ConnConfig, newLoadInfo *ClusterLoadInfo,
+	leastLoadedHost *lbHost) (c *Conn, attempts int, er error) {
+	m := config.clusterManager()
+	internalFallbacks := 0
+	attemptCtx, cancel := connectAttemptContext(ctx, config, config.MaxConnectRetries)
+	conn, err := connectAttempt(attemptCtx, config, newLoadInfo, &internalFallbacks)
+	cancel()
+	attempts = 1
+	for i := 0; i < config.MaxConnectRetries && err != nil; i++ {
+		if config.ShouldRetryConnect != nil && !config.ShouldRetryConnect(config.Host, attempts, err) {
+			break
 		}
-		if timeout > 0 {
-			ctx, _ = context.WithTimeout(context.Background(), timeout)
-		} else {
-			ctx = context.Background()
+		m.decrementConnCount(config.loadCountKey())
+		if backoff := connectRetryBackoff(config, attempts); backoff > 0 {
+			if err := sleepContext(ctx, backoff); err != nil {
+				return nil, attempts, err
+			}
 		}
-		if leastLoadedHost.hostname == config.Host {
-			conn, err = connect(ctx, config)
-		} else {
-			/*
-				Replacing Host, port, Fallbacks list and connstring in the user config,
-				as per the least loaded server received.
-			*/
-			newConnString := replaceHostString(config.connString, leastLoadedHost.hostname, leastLoadedHost.port)
-			newConfig, err1 := ParseConfig(newConnString)
-			if err1 != nil {
-				return nil, err1
-			}
-			config.Host = newConfig.Host
-			config.Port = newConfig.Port
-			config.Fallbacks = newConfig.Fallbacks
-			config.controlHost = controlHost
-			config.connString = newConfig.connString
-			conn, err = connect(ctx, config)
+		lbLogf(config, LBLogLevelWarn, "Adding %s to unavailableHosts due to %s", config.Host, redactSecrets(err.Error()))
+		leastLoadedHost = m.requestHost(newRetryRequest(ctx, newLoadInfo, leastLoadedHost.hostname))
+		if leastLoadedHost.err != nil {
+			return nil, attempts, leastLoadedHost.err
 		}
+		if leastLoadedHost.hostname != config.Host {
+			config = config.cloneWithHost(leastLoadedHost.hostname, leastLoadedHost.port)
+		}
+		config.countedHost = leastLoadedHost.countedHost
+		attempts++
+		attemptCtx, cancel := connectAttemptContext(ctx, config, config.MaxConnectRetries-i-1)
+		conn, err = connectAttempt(attemptCtx, config, newLoadInfo, &internalFallbacks)
+		cancel()
 	}
 	if err != nil {
-		decrementConnCount(config.controlHost + "," + config.Host)
+		m.decrementConnCount(config.loadCountKey())
+		return nil, attempts, err
 	}
-	return conn, err
+	conn.selectionGeneration = leastLoadedHost.generation
+	conn.lbDecision = leastLoadedHost.decision()
+	conn.lbDecision.InternalFallbacks = internalFallbacks
+	return conn, attempts, nil
 }
 
-func decrementConnCount(str string) {
-	requestChan <- &ClusterLoadInfo{
-		clusterName: str,
-		flags:       DECREMENT_COUNT,
+// validateLBConfig checks the exported load balancing settings of config, which may have been changed since
+// ParseConfig.
+func validateLBConfig(config *ConnConfig) error {
+	switch {
+	case config.RefreshInterval < 0 || config.RefreshInterval > MAX_INTERVAL_SECONDS*time.Second:
+		return fmt.Errorf("invalid RefreshInterval %s, must be between 0 and %ds", config.RefreshInterval,
+			MAX_INTERVAL_SECONDS)
+	case config.ControlConnTimeout <= 0 || config.ControlConnTimeout > MAX_INTERVAL_SECONDS*time.Second:
+		return fmt.Errorf("invalid ControlConnTimeout %s, must be positive and at most %ds", config.ControlConnTimeout,
+			MAX_INTERVAL_SECONDS)
+	case config.MaxConnectRetries < 0:
+		return fmt.Errorf("invalid MaxConnectRetries %d, must not be negative", config.MaxConnectRetries)
 	}
+	return nil
 }
 
-func markHostAway(li *ClusterLoadInfo, h string) {
-	log.Warn().Msgf("Marking host %s as unreachable", h)
-	delete(li.hostLoadPrimary, h)
-	delete(li.hostLoadRR, h)
-	delete(li.hostPairs, h)
-	if li.unavailableHosts == nil {
-		li.unavailableHosts = make(map[string]int64)
+// lbCircuit counts the consecutive failures of the load balancer to select a server of a cluster. Once there are
+// config.circuitFailures of them, the circuit opens and connects to the cluster are not load balanced until openUntil.
+type lbCircuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+// lbCircuits holds the circuit of each cluster of a ClusterManager. Unlike the load information of the clusters, they
+// are kept for clusters whose load information could never be created.
+type lbCircuits struct {
+	sync.Mutex
+	m map[string]*lbCircuit
+}
+
+func (m *ClusterManager) isCircuitOpen(clusterName string) bool {
+	m.circuits.Lock()
+	defer m.circuits.Unlock()
+	circuit, ok := m.circuits.m[clusterName]
+	return ok && time.Now().Before(circuit.openUntil)
+}
+
+// recordLoadBalancerResult updates the circuit of the cluster with err, the error of a server selection. Errors caused
+// by the configuration of the connect, rather than by the load balancer failing, are ignored.
+func (m *ClusterManager) recordLoadBalancerResult(clusterName string, config *ConnConfig, err error) {
+	if err == ErrFallbackToOriginalBehaviour || err == ErrTooFewEligibleHosts || err == ErrLoadBalancerShutdown {
+		return
+	}
+	m.circuits.Lock()
+	defer m.circuits.Unlock()
+	circuit, ok := m.circuits.m[clusterName]
+	if !ok {
+		circuit = &lbCircuit{}
+		m.circuits.m[clusterName] = circuit
+	}
+	if err == nil {
+		if !circuit.openUntil.IsZero() {
+			lbLogf(config, LBLogLevelInfo, "Load balancing recovered for cluster %s", clusterName)
+			circuit.openUntil = time.Time{}
+			emitLBEvent(config, LBEvent{Type: LBEventCircuitClosed, ClusterName: clusterName})
+		}
+		circuit.failures = 0
+		return
+	}
+	circuit.failures++
+	if circuit.failures >= config.circuitFailures {
+		lbLogf(config, LBLogLevelWarn,
+			"Load balancing failed %d times in a row for cluster %s, connecting directly for %d seconds",
+			circuit.failures, clusterName, config.circuitCooldownSecs)
+		circuit.openUntil = time.Now().Add(time.Duration(config.circuitCooldownSecs) * time.Second)
+		emitLBEvent(config, LBEvent{Type: LBEventCircuitOpened, ClusterName: clusterName})
 	}
-	li.unavailableHosts[h] = time.Now().Unix()
 }
 
-func refreshLoadInfo(li *ClusterLoadInfo) error {
-	li.ctrlCtx, _ = context.WithTimeout(context.Background(), CONTROL_CONN_TIMEOUT)
-	if li.controlConn == nil || li.controlConn.IsClosed() {
-		var err error
-		ctrlConfig, err := ParseConfig(li.config.connString)
-		if err != nil {
-			log.Err(err).Msgf("refreshLoadInfo(): ParseConfig for control connection failed, %s", err.Error())
-			return err
+// connectRateLimiter spaces the load balanced connects to a cluster evenly, as a token bucket holding a single token.
+type connectRateLimiter struct {
+	interval time.Duration
+	// time at which the next connect may start
+	next time.Time
+}
+
+// connectRateLimiters holds the limiter of each cluster of a ClusterManager. Connects wait on them without holding the
+// lock of the load information of their cluster, so they are kept apart from it.
+type connectRateLimiters struct {
+	sync.Mutex
+	m map[string]*connectRateLimiter
+}
+
+// waitConnectRate blocks until a connect to the cluster is allowed by config.connectRate, or returns
+// ErrConnectRateExceeded instead of blocking if config.connectRateFail is set.
+func (m *ClusterManager) waitConnectRate(ctx context.Context, clusterName string, config *ConnConfig) error {
+	interval := time.Duration(float64(time.Second) / config.connectRate)
+	now := time.Now()
+
+	m.rateLimiters.Lock()
+	l, ok := m.rateLimiters.m[clusterName]
+	if !ok {
+		l = &connectRateLimiter{}
+		m.rateLimiters.m[clusterName] = l
+	}
+	l.interval = interval
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	if wait > 0 && config.connectRateFail {
+		m.rateLimiters.Unlock()
+		return ErrConnectRateExceeded
+	}
+	l.next = l.next.Add(l.interval)
+	m.rateLimiters.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	return sleepContext(ctx, wait)
+}
+
+// connectRetryBackoff returns the time waited before trying another server after attempt failed connects.
+func connectRetryBackoff(config *ConnConfig, attempt int) time.Duration {
+	if config.ConnectRetryBackoff != nil {
+		return config.ConnectRetryBackoff(attempt)
+	}
+	if config.connectRetryMaxBackoff > config.connectRetryBackoff && config.connectRetryBackoff > 0 {
+		return ExponentialBackoff(config.connectRetryBackoff, config.connectRetryMaxBackoff)(attempt)
+	}
+	return config.connectRetryBackoff
+}
+
+// connectAttemptContext returns the context of a load balanced connect attempt made with ctx, after which up to
+// retriesLeft other servers may be tried. The attempt is given config.connectAttemptTimeout if it is set. Otherwise,
+// if ctx has a deadline and other servers may be tried afterwards, it is given CONNECT_ATTEMPT_DEADLINE_FRACTION of
+// the time left, so that a server which does not answer leaves time to try the others.
+func connectAttemptContext(ctx context.Context, config *ConnConfig, retriesLeft int) (context.Context,
+	context.CancelFunc) {
+	if config.connectAttemptTimeout > 0 {
+		return context.WithTimeout(ctx, config.connectAttemptTimeout)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || retriesLeft <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(float64(time.Until(deadline))*CONNECT_ATTEMPT_DEADLINE_FRACTION))
+}
+
+// ExponentialBackoff returns a ConnectRetryBackoff doubling base after every failed attempt up to max. The time
+// returned is jittered between half of it and all of it, so that clients failing together do not retry together.
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
 		}
-		/*
-			Replacing Host, port, Fallbacks list and connstring in the user config,
-			as per the host on which control connection is attempted.
-		*/
-		li.config.Host = LookupIP(ctrlConfig.Host)
-		li.config.Port = ctrlConfig.Port
-		li.config.Fallbacks = ctrlConfig.Fallbacks
-		li.config.connString = ctrlConfig.connString
-		li.config.ConnectTimeout = CONTROL_CONN_TIMEOUT
-		li.controlConn, err = connect(li.ctrlCtx, li.config)
-		if err != nil {
-			log.Warn().Msgf("Could not create control connection to %s\n", li.config.Host)
-			// remove its hostLoad entry
-			markHostAway(li, li.config.Host)
-			li.controlConn = nil
-			// Attempt connection to other servers which are already fetched in cli.
-			if len(li.hostPairs) > 0 {
-				log.Warn().Msgf("Attempting control connection to %d other servers ...\n", len(li.hostPairs))
-			}
-			for h := range li.hostPairs {
-				newConnString := replaceHostString(li.config.connString, h, li.hostPort[h])
-				/*
-					Replacing Host, port, Fallbacks list and connstring in the user config,
-					as per the host on which control connection is attempted.
-				*/
-				if ctrlConfig, err = ParseConfig(newConnString); err == nil {
-					li.config.Host = ctrlConfig.Host
-					li.config.Port = ctrlConfig.Port
-					li.config.Fallbacks = ctrlConfig.Fallbacks
-					li.config.connString = ctrlConfig.connString
-					li.config.ConnectTimeout = CONTROL_CONN_TIMEOUT
-					li.ctrlCtx, _ = context.WithTimeout(context.Background(), CONTROL_CONN_TIMEOUT)
-					if li.controlConn, err = connect(li.ctrlCtx, li.config); err == nil {
-						log.Info().Msgf("Created control connection to host %s", h)
-						break
-					}
-					log.Warn().Msgf("Could not create control connection to host %s", h)
-					markHostAway(li, li.config.Host)
-					li.controlConn = nil
-				}
-			}
-			if err != nil {
-				log.Err(err).Msg("Failed to create control connection")
-				return err
-			}
+		if d > max {
+			d = max
 		}
-		li.config.controlHost = li.config.Host
+		if d <= 1 {
+			return d
+		}
+		return d/2 + time.Duration(mathrand.Int63n(int64(d/2)+1))
 	}
-	// defer li.controlConn.Close(li.ctrlCtx)
+}
 
-	rows, err := li.controlConn.Query(li.ctrlCtx, LB_QUERY)
-	if err != nil {
-		log.Err(err).Msgf("Could not query load information: %s", err.Error())
-		markHostAway(li, li.config.controlHost)
-		li.controlConn = nil
-		return refreshLoadInfo(li)
+// sleepContext waits for d, or returns the error of ctx if it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	defer rows.Close()
-	var host, nodeType, cloud, region, zone, publicIP string
-	var port, numConns int
-	newHostLoadPrimary := make(map[string]int)
-	newHostLoadRR := make(map[string]int)
-	newHostPort := make(map[string]uint16)
+}
+
+// RefreshAndConnect refreshes the servers of the cluster connString belongs to, regardless of
+// yb_servers_refresh_interval, and then connects like Connect. The connection is thus load balanced over the servers of
+// the cluster at the time of the call, which is mostly useful in tests changing the cluster.
+func RefreshAndConnect(ctx context.Context, connString string) (*Conn, error) {
+	err := inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		return refreshNow(ctx, li)
+	})
+	if err != nil && !errors.Is(err, ErrNoLoadInfo) {
+		return nil, err
+	}
+	return Connect(ctx, connString)
+}
+
+// RefreshClusterInfo refreshes the servers of the cluster clusterName, the host load balanced connections to it were
+// made with or their cluster_name, see ConnConfig.ClusterName, right away rather than once yb_servers_refresh_interval
+// elapsed, e.g. after servers were added to it. It returns ErrNoLoadInfo if no such connection was made with the
+// default ClusterManager, and does nothing while the refresh of the cluster is paused.
+func RefreshClusterInfo(ctx context.Context, clusterName string) error {
+	return defaultClusterManager.RefreshClusterInfo(ctx, clusterName)
+}
+
+// RefreshClusterInfo refreshes the servers of the cluster clusterName as known by m, see RefreshClusterInfo.
+func (m *ClusterManager) RefreshClusterInfo(ctx context.Context, clusterName string) error {
+	return m.withCluster(m.clusterKey(clusterName), func(li *ClusterLoadInfo) error {
+		return refreshNow(ctx, li)
+	})
+}
+
+// refreshNow refreshes the servers of li with ctx, unless its refresh is paused.
+func refreshNow(ctx context.Context, li *ClusterLoadInfo) error {
+	if li.refreshPaused {
+		return nil
+	}
+	li.ctx = ctx
+	return refreshLoadInfo(li)
+}
+
+// Reconnect replaces the connection to the server of a load balanced connection with a connection to another server of
+// the cluster, selected as by Connect with the current server marked as unavailable. It is meant for connections
+// whose server went down. The *Conn is kept along with its type map and tracers, but the session state of the server,
+// e.g. prepared statements, is lost as with a new connection. If no other server can be connected to, the connection
+// is left as it was.
+func (c *Conn) Reconnect(ctx context.Context) error {
+	if c.lbConfig == nil {
+		return errors.New("only load balanced connections can reconnect")
+	}
+	config := c.lbConfig.Copy()
+	if config.OnNotification == nil {
+		// The notifications of the new connection are buffered for c, not for the Conn made by the connect.
+		config.OnNotification = c.bufferNotifications
+	}
+	// The server is marked by its private address, which the hosts marked unavailable are kept under.
+	markHostUnavailable(config.clusterManager(), config.ClusterName(), c.config.loadCountHost())
+	newConn, err := connectWithLoadBalancer(ctx, config)
+	if err != nil {
+		return err
+	}
+	// Decrements the connection count of the previous server.
+	c.Close(ctx)
+	// Only the state of the connection to the server is taken from newConn.
+	c.pgConn = newConn.pgConn
+	c.config = newConn.config
+	c.preparedStatements = newConn.preparedStatements
+	c.statementCache = newConn.statementCache
+	c.descriptionCache = newConn.descriptionCache
+	c.notifications = nil
+	c.closeCntUpdated = newConn.closeCntUpdated
+	c.selectionGeneration = newConn.selectionGeneration
+	c.connectAttempts = newConn.connectAttempts
+	c.lbDecision = newConn.lbDecision
+	return nil
+}
+
+// MarkHostUnavailable excludes host, the private or public address of a server of the cluster clusterName, from the
+// servers load balanced connections to the cluster are made to for duration, or until UnmarkHost if duration is not
+// positive, e.g. to drain the server before maintenance. clusterName is the host load balanced connections to the
+// cluster were made with, or their cluster_name. Unlike the servers marked unavailable after failed connects, it is
+// not made available again by a refresh. It returns ErrNoLoadInfo if no such connection was made with the default
+// ClusterManager.
+//
+// The connections already made to the server are kept. Pools with a RebalanceInterval gradually close their idle ones.
+func MarkHostUnavailable(clusterName string, host string, duration time.Duration) error {
+	return defaultClusterManager.MarkHostUnavailable(clusterName, host, duration)
+}
+
+// MarkHostUnavailable excludes host from the servers of the cluster clusterName as known by m, see
+// MarkHostUnavailable.
+func (m *ClusterManager) MarkHostUnavailable(clusterName string, host string, duration time.Duration) error {
+	return m.withCluster(m.clusterKey(clusterName), func(li *ClusterLoadInfo) error {
+		if li.drainedHosts == nil {
+			li.drainedHosts = make(map[string]time.Time)
+		}
+		var until time.Time
+		if duration > 0 {
+			until = time.Now().Add(duration)
+		}
+		host = LookupIP(host)
+		li.drainedHosts[host] = until
+		emitLBEvent(li.config, LBEvent{Type: LBEventHostMarkedAway, ClusterName: li.clusterName, Host: host})
+		return nil
+	})
+}
+
+// UnmarkHost makes host, excluded by MarkHostUnavailable, available again to the load balanced connections to the
+// cluster clusterName. It returns ErrNoLoadInfo if no such connection was made with the default ClusterManager.
+func UnmarkHost(clusterName string, host string) error {
+	return defaultClusterManager.UnmarkHost(clusterName, host)
+}
+
+// UnmarkHost makes host available again to the cluster clusterName as known by m, see UnmarkHost.
+func (m *ClusterManager) UnmarkHost(clusterName string, host string) error {
+	return m.withCluster(m.clusterKey(clusterName), func(li *ClusterLoadInfo) error {
+		host = LookupIP(host)
+		if _, ok := li.drainedHosts[host]; ok {
+			delete(li.drainedHosts, host)
+			emitLBEvent(li.config, LBEvent{Type: LBEventHostRecovered, ClusterName: li.clusterName, Host: host})
+		}
+		return nil
+	})
+}
+
+// ResetHostBackoff makes host, marked unavailable after failed connects, available again to the load balanced
+// connections to the cluster clusterName, and forgets the times it was marked so that its next failure makes it
+// unavailable for failed_host_reconnect_delay_secs only. It returns ErrNoLoadInfo if no such connection was made with
+// the default ClusterManager.
+func ResetHostBackoff(clusterName string, host string) error {
+	return defaultClusterManager.ResetHostBackoff(clusterName, host)
+}
+
+// ResetHostBackoff resets the backoff of host for the cluster clusterName as known by m, see ResetHostBackoff.
+func (m *ClusterManager) ResetHostBackoff(clusterName string, host string) error {
+	return m.withCluster(m.clusterKey(clusterName), func(li *ClusterLoadInfo) error {
+		host = LookupIP(host)
+		delete(li.awayCounts, host)
+		if _, ok := li.unavailableHosts[host]; ok {
+			lbLogf(li.config, LBLogLevelInfo, "Removing %s from unavailableHosts Map", host)
+			restoreHost(li, host)
+		}
+		return nil
+	})
+}
+
+// expireDrainedHosts makes the hosts whose MarkHostUnavailable duration elapsed available again.
+func expireDrainedHosts(li *ClusterLoadInfo) {
+	now := time.Now()
+	for h, until := range li.drainedHosts {
+		if !until.IsZero() && !now.Before(until) {
+			lbLogf(li.config, LBLogLevelInfo, "Removing %s from drained hosts", h)
+			delete(li.drainedHosts, h)
+			emitLBEvent(li.config, LBEvent{Type: LBEventHostRecovered, ClusterName: li.clusterName, Host: h})
+		}
+	}
+}
+
+// isHostDrained reports whether h, the private address of a server, is excluded by MarkHostUnavailable by its private
+// or public address.
+func isHostDrained(li *ClusterLoadInfo, h string) bool {
+	for _, d := range []string{h, li.hostPairs[h]} {
+		if until, ok := li.drainedHosts[d]; ok && d != "" && (until.IsZero() || time.Now().Before(until)) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRebalance reports whether the load balancer would no longer select the server of c for a new connection made
+// with the same config. That is the case if the server is no longer eligible, e.g. because a server of a more
+// preferred topology_keys tier is available again, or if it has more than one connection more than the least loaded
+// eligible server, e.g. because servers were added to the cluster. It is false for connections which are not load
+// balanced or if no server is eligible.
+func (c *Conn) NeedsRebalance() bool {
+	if c.lbConfig == nil || c.config.countedHost == "" {
+		return false
+	}
+	m := c.lbConfig.clusterManager()
+	return m.needsRebalance(c.lbConfig.ClusterName(), c.lbConfig, c.config.countedHost)
+}
+
+// needsRebalance reports whether host, the server a connection made with config is counted against, is no longer
+// eligible or is loaded more than the least loaded eligible server by more than one connection.
+func (m *ClusterManager) needsRebalance(clusterName string, config *ConnConfig, host string) (rebalance bool) {
+	m.withCluster(clusterName, func(li *ClusterLoadInfo) error {
+		// The servers are selected as for config, without changing the settings the cluster is refreshed with.
+		shared := li.config
+		requestConfig := *shared
+		applyRequestConfig(&requestConfig, config)
+		li.config = &requestConfig
+		hostload, eligible, _, _, err := eligibleHosts(li, false)
+		li.config = shared
+		if err != nil || len(eligible) == 0 {
+			return nil
+		}
+		rebalance = true
+		for _, h := range eligible {
+			if h == host {
+				leastCnt, _ := leastLoadedOf(hostload, eligible)
+				rebalance = hostload[host] > leastCnt+1
+				break
+			}
+		}
+		return nil
+	})
+	return rebalance
+}
+
+// markHostUnavailable marks host unavailable in the load information of the cluster clusterName, if there is any.
+func markHostUnavailable(m *ClusterManager, clusterName string, host string) {
+	m.withCluster(clusterName, func(li *ClusterLoadInfo) error {
+		if li.unavailableHosts != nil {
+			addUnavailableHost(li, host, time.Now().Unix())
+			emitLBEvent(li.config, LBEvent{Type: LBEventHostMarkedAway, ClusterName: li.clusterName, Host: host})
+		}
+		return nil
+	})
+}
+
+// TimeSinceLastSuccessfulRefresh returns the time elapsed since the servers of the cluster connString belongs to were
+// last refreshed successfully. It keeps growing while refreshes fail, which makes it suitable for alerting. It returns
+// ErrNoLoadInfo if no load balanced connection was made to the cluster with the default ClusterManager.
+func TimeSinceLastSuccessfulRefresh(connString string) (time.Duration, error) {
+	return defaultClusterManager.TimeSinceLastSuccessfulRefresh(connString)
+}
+
+// TimeSinceLastSuccessfulRefresh returns the time elapsed since the servers of the cluster connString belongs to were
+// last refreshed successfully by m, see TimeSinceLastSuccessfulRefresh.
+func (m *ClusterManager) TimeSinceLastSuccessfulRefresh(connString string) (time.Duration, error) {
+	var since time.Duration
+	err := m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		since = time.Since(li.lastSuccessfulRefresh)
+		return nil
+	})
+	return since, err
+}
+
+// ControlHost returns the host the control connection of the cluster connString belongs to is made to, and since when.
+// The control connection is used to refresh the servers of the cluster. It returns ErrNoLoadInfo if no load balanced
+// connection was made to the cluster with the default ClusterManager.
+func ControlHost(connString string) (host string, since time.Time, err error) {
+	return defaultClusterManager.ControlHost(connString)
+}
+
+// ControlHost returns the host the control connection of the cluster connString belongs to is made to by m, see
+// ControlHost.
+func (m *ClusterManager) ControlHost(connString string) (host string, since time.Time, err error) {
+	err = m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		host = li.config.controlHost
+		since = li.controlHostSince
+		return nil
+	})
+	return host, since, err
+}
+
+// PauseRefresh stops refreshing the servers of the cluster connString belongs to, e.g. while yb_servers() may report
+// a transient topology during maintenance. Connects keep being balanced across the servers of the last refresh until
+// ResumeRefresh is called. It returns ErrNoLoadInfo, and pauses nothing, if no load balanced connection was made to
+// the cluster with the default ClusterManager yet.
+func PauseRefresh(connString string) error {
+	return defaultClusterManager.PauseRefresh(connString)
+}
+
+// PauseRefresh stops refreshing the servers of the cluster connString belongs to as known by m, see PauseRefresh.
+func (m *ClusterManager) PauseRefresh(connString string) error {
+	return m.setRefreshPaused(connString, true)
+}
+
+// ResumeRefresh undoes PauseRefresh. The servers are refreshed by the next connect if the refresh interval elapsed
+// in the meantime. It returns ErrNoLoadInfo if no load balanced connection was made to the cluster with the default
+// ClusterManager yet.
+func ResumeRefresh(connString string) error {
+	return defaultClusterManager.ResumeRefresh(connString)
+}
+
+// ResumeRefresh undoes PauseRefresh for the cluster connString belongs to as known by m, see ResumeRefresh.
+func (m *ClusterManager) ResumeRefresh(connString string) error {
+	return m.setRefreshPaused(connString, false)
+}
+
+func (m *ClusterManager) setRefreshPaused(connString string, paused bool) error {
+	return m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		li.refreshPaused = paused
+		return nil
+	})
+}
+
+// newRetryRequest returns a GET_LB_CONN request for the cluster of li, reporting host as unavailable. li itself must
+// not be reused since it becomes the load information of the cluster when it is the first request for it.
+func newRetryRequest(ctx context.Context, li *ClusterLoadInfo, host string) *ClusterLoadInfo {
+	return &ClusterLoadInfo{
+		clusterName:      li.clusterName,
+		ctx:              ctx,
+		config:           li.config,
+		flags:            GET_LB_CONN,
+		unavailableHosts: map[string]int64{host: time.Now().Unix()},
+	}
+}
+
+// connectAttempt makes a single connection attempt to config.Host and records how long it took if it succeeded. A
+// successful connection is discarded if the host got quarantined in the meantime. The number of other hosts connect
+// went on to, i.e. the Fallbacks of config or other addresses of Host, is added to fallbacks.
+func connectAttempt(ctx context.Context, config *ConnConfig, li *ClusterLoadInfo, fallbacks *int) (*Conn, error) {
+	if config.disableFallbacks {
+		config.Fallbacks = nil
+	}
+	if dial := config.DialFunc; dial != nil {
+		dialed := make(map[string]bool)
+		config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed[addr] = true
+			return dial(ctx, network, addr)
+		}
+		defer func() {
+			config.DialFunc = dial
+			if len(dialed) > 1 {
+				lbLogf(config, LBLogLevelInfo, "Connect to %s went on to %d other hosts", config.Host, len(dialed)-1)
+				*fallbacks += len(dialed) - 1
+			}
+		}()
+	}
+	start := time.Now()
+	conn, err := connect(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	m := config.clusterManager()
+	if checkDataConnect(m, li.clusterName, config.Host) {
+		// The host was marked away while this connection was being established.
+		conn.pgConn.Close(ctx)
+		return nil, fmt.Errorf("host %s is quarantined after being marked away", config.Host)
+	}
+	recordConnectLatency(m, li.clusterName, config.Host, time.Since(start))
+	return conn, nil
+}
+
+// quarantineHost makes connections to h, and to its public address, unacceptable for config.quarantineSecs.
+func quarantineHost(li *ClusterLoadInfo, h string) {
+	if li.config.quarantineSecs <= 0 {
+		return
+	}
+	if li.quarantinedUntil == nil {
+		li.quarantinedUntil = make(map[string]time.Time)
+	}
+	until := time.Now().Add(time.Duration(li.config.quarantineSecs) * time.Second)
+	li.quarantinedUntil[h] = until
+	if public := li.hostPairs[h]; public != "" {
+		li.quarantinedUntil[public] = until
+	}
+}
+
+// checkDataConnect reports whether host is quarantined, in which case the connection must be discarded, and records
+// the successful connect to host otherwise. A discarded connection leaves the failures of host as they are.
+func checkDataConnect(m *ClusterManager, clusterName string, host string) (quarantined bool) {
+	m.withCluster(clusterName, func(li *ClusterLoadInfo) error {
+		if until, ok := li.quarantinedUntil[host]; ok {
+			if time.Now().Before(until) {
+				quarantined = true
+				return nil
+			}
+			delete(li.quarantinedUntil, host)
+		}
+		recordDataConnectSuccess(li, host)
+		delete(li.awayCounts, host)
+		return nil
+	})
+	return quarantined
+}
+
+func (m *ClusterManager) decrementConnCount(str string) {
+	// The cluster_name of a cluster may contain commas, a host does not.
+	i := strings.LastIndex(str, ",")
+	if i < 0 {
+		lbLogf(nil, LBLogLevelWarn, "cannot parse names to update connection count: %s", str)
+		return
+	}
+	names := []string{str[:i], str[i+1:]}
+	clusterName := m.clusterKey(names[0])
+	m.mu.RLock()
+	cli, ok := m.clusters[clusterName]
+	m.mu.RUnlock()
+	if !ok {
+		return
+	}
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	host := currentHost(cli, names[1]) // the server may have moved since the connection was counted
+	cnt, found := cli.hostLoadPrimary[host]
+	if found {
+		if cnt != 0 {
+			cli.hostLoadPrimary[host] = cnt - 1
+		}
+	} else if cnt, found = cli.hostLoadRR[host]; found {
+		if cnt != 0 {
+			cli.hostLoadRR[host] = cnt - 1
+		}
+	}
+}
+
+// inspectLoadInfo runs fn with the clusters of the default ClusterManager, see ClusterManager.inspectLoadInfo.
+func inspectLoadInfo(fn func(map[string]*ClusterLoadInfo) error) error {
+	return defaultClusterManager.inspectLoadInfo(fn)
+}
+
+// inspectLoadInfo runs fn with the clusters of m and all of them locked, so fn may read and modify them freely. It
+// waits for the refreshes in progress, use withCluster to only access one cluster.
+func (m *ClusterManager) inspectLoadInfo(fn func(map[string]*ClusterLoadInfo) error) error {
+	for {
+		clis := m.sortedClusters()
+		for _, li := range clis {
+			li.mu.Lock()
+		}
+		m.mu.Lock()
+		unchanged := len(clis) == len(m.clusters)
+		for _, li := range clis {
+			unchanged = unchanged && m.clusters[li.clusterName] == li
+		}
+		stopped := m.stopped
+		var err error
+		if stopped {
+			err = ErrLoadBalancerShutdown
+		} else if unchanged {
+			err = fn(m.clusters)
+		}
+		m.mu.Unlock()
+		for _, li := range clis {
+			li.mu.Unlock()
+		}
+		if stopped || unchanged {
+			return err
+		}
+		// A cluster was added or removed while the clusters were being locked, lock the current ones.
+	}
+}
+
+// clusterKey returns the key of the cluster clusterName in m: clusterName itself if it is the cluster_name of a
+// cluster, the address of the host clusterName otherwise.
+func (m *ClusterManager) clusterKey(clusterName string) string {
+	m.mu.RLock()
+	_, named := m.clusters[clusterName]
+	m.mu.RUnlock()
+	if named {
+		return clusterName
+	}
+	return LookupIP(clusterName)
+}
+
+// withCluster runs fn with the load information of the cluster clusterName locked. It returns ErrNoLoadInfo if m has
+// none for that cluster.
+func (m *ClusterManager) withCluster(clusterName string, fn func(li *ClusterLoadInfo) error) error {
+	m.mu.RLock()
+	li, ok := m.clusters[clusterName]
+	stopped := m.stopped
+	m.mu.RUnlock()
+	if stopped {
+		return ErrLoadBalancerShutdown
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoLoadInfo, clusterName)
+	}
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	return fn(li)
+}
+
+// lastRequestID is the ID of the last request served on a Go routine of its own, replies carry the ID of their request.
+var lastRequestID uint64
+
+// mismatchedReplies counts the replies discarded by requestHost.
+var mismatchedReplies uint64
+
+var errMismatchedReply = errors.New("received the reply to another load balancer request")
+
+// requestHost returns the least loaded server of the cluster of req. It is served on the caller's Go routine if the
+// load information of the cluster is available right away. Otherwise it is served on a Go routine of its own, so that
+// it fails with ErrLoadBalancerShutdown as soon as m is shut down, even if a refresh is in progress. The request and
+// its reply are then tagged with an ID, and a reply to another request, which would mean that requests and replies
+// got mixed up, is discarded and reported as errMismatchedReply.
+func (m *ClusterManager) requestHost(req *ClusterLoadInfo) (lbh *lbHost) {
+	m.mu.RLock()
+	if m.stopped {
+		m.mu.RUnlock()
+		return &lbHost{err: ErrLoadBalancerShutdown}
+	}
+	m.requests.Add(1)
+	old, present := m.clusters[req.clusterName]
+	m.mu.RUnlock()
+
+	if present && old.mu.TryLock() {
+		defer m.requests.Done()
+		defer m.recoverRequest(req, &lbh)
+		return serveLocked(old, req)
+	}
+	req.requestID = atomic.AddUint64(&lastRequestID, 1)
+	reply := make(chan *lbHost, 1)
+	go func() {
+		defer m.requests.Done()
+		lbh := m.safeServe(req)
+		lbh.requestID = req.requestID
+		reply <- lbh
+	}()
+	select {
+	case lbh := <-reply:
+		if lbh.requestID != req.requestID {
+			atomic.AddUint64(&mismatchedReplies, 1)
+			lbLogf(req.config, LBLogLevelError, "Discarding reply to load balancer request %d received for request %d",
+				lbh.requestID, req.requestID)
+			return &lbHost{requestID: req.requestID, err: errMismatchedReply}
+		}
+		return lbh
+	case <-m.shutdown:
+		return &lbHost{err: ErrLoadBalancerShutdown}
+	}
+}
+
+// safeServe is serve recovering from a panic, see recoverRequest.
+func (m *ClusterManager) safeServe(req *ClusterLoadInfo) (lbh *lbHost) {
+	defer m.recoverRequest(req, &lbh)
+	return m.serve(req)
+}
+
+// recoverRequest, deferred by the requests to m, recovers from a panic serving req. It sets *lbh to an error wrapping
+// ErrLoadBalancerPanicked, so that the connect falls back to the original behaviour, and degrades m for
+// DEGRADED_MODE_DURATION. The load information of the cluster, which the panic may have left half updated, is
+// refreshed by the next request.
+func (m *ClusterManager) recoverRequest(req *ClusterLoadInfo, lbh **lbHost) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	lbLogf(req.config, LBLogLevelError,
+		"Load balancer panicked serving a connect to %s, connects are not load balanced for %s: %v\n%s",
+		req.clusterName, DEGRADED_MODE_DURATION, r, debug.Stack())
+	m.mu.Lock()
+	m.degradedUntil = time.Now().Add(DEGRADED_MODE_DURATION)
+	li, ok := m.clusters[req.clusterName]
+	m.mu.Unlock()
+	if ok {
+		li.mu.Lock()
+		li.lastRefresh = time.Time{}
+		li.mu.Unlock()
+	}
+	*lbh = &lbHost{err: fmt.Errorf("%w: %v", ErrLoadBalancerPanicked, r)}
+}
+
+// LoadBalancerDegraded reports whether load balancing is disabled because a request to the default ClusterManager
+// panicked, see ClusterManager.Degraded.
+func LoadBalancerDegraded() bool {
+	return defaultClusterManager.Degraded()
+}
+
+// Degraded reports whether load balancing is disabled because a request to m panicked less than
+// DEGRADED_MODE_DURATION ago. The load balanced connects made with m meanwhile connect to the hosts of their config
+// like connects without load_balance, and are load balanced again afterwards.
+func (m *ClusterManager) Degraded() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return time.Now().Before(m.degradedUntil)
+}
+
+// inspectCluster runs fn on the load information of the cluster connString belongs to in the default ClusterManager.
+// It returns ErrNoLoadInfo if no load balanced connection has been made to that cluster yet.
+func inspectCluster(connString string, fn func(li *ClusterLoadInfo) error) error {
+	return defaultClusterManager.inspectCluster(connString, fn)
+}
+
+// inspectCluster runs fn on the load information of the cluster connString belongs to. It returns ErrNoLoadInfo if
+// no load balanced connection has been made to that cluster with m yet.
+func (m *ClusterManager) inspectCluster(connString string, fn func(li *ClusterLoadInfo) error) error {
+	config, err := ParseConfig(connString)
+	if err != nil {
+		return err
+	}
+	return m.withCluster(config.ClusterName(), fn)
+}
+
+// RawServerList runs the query listing the servers, LB_QUERY unless yb_servers_query is set, on the control connection
+// of the cluster connString belongs to and returns the rows as they are reported by the server, one map of column name
+// to value per tserver. It is meant for diagnostics, e.g. to compare the driver's view of the cluster with the output
+// of yb_servers(). It returns ErrNoLoadInfo if no load balanced connection was made to the cluster with the default
+// ClusterManager.
+func RawServerList(ctx context.Context, connString string) ([]map[string]any, error) {
+	return defaultClusterManager.RawServerList(ctx, connString)
+}
+
+// RawServerList returns the servers of the cluster connString belongs to as reported to the control connection of m,
+// see RawServerList.
+func (m *ClusterManager) RawServerList(ctx context.Context, connString string) ([]map[string]any, error) {
+	var servers []map[string]any
+	err := m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		if li.controlConn == nil || li.controlConn.IsClosed() {
+			if li.refreshPaused {
+				return fmt.Errorf("no control connection to %s while its refreshes are paused", li.clusterName)
+			}
+			li.ctx = ctx
+			if err := refreshLoadInfo(li); err != nil {
+				return err
+			}
+		}
+		rows, err := li.controlConn.Query(ctx, serversQuery(li))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		fields := rows.FieldDescriptions()
+		for rows.Next() {
+			values, err := rows.Values()
+			if err != nil {
+				return err
+			}
+			server := make(map[string]any, len(fields))
+			for i, fd := range fields {
+				server[fd.Name] = values[i]
+			}
+			servers = append(servers, server)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+func markHostAway(li *ClusterLoadInfo, h string) {
+	lbLogf(li.config, LBLogLevelWarn, "Marking host %s as unreachable", h)
+	quarantineHost(li, h)
+	delete(li.hostLoadPrimary, h)
+	delete(li.hostLoadRR, h)
+	delete(li.hostPairs, h)
+	if li.unavailableHosts == nil {
+		li.unavailableHosts = make(map[string]int64)
+	}
+	addUnavailableHost(li, h, time.Now().Unix())
+	emitLBEvent(li.config, LBEvent{Type: LBEventHostMarkedAway, ClusterName: li.clusterName, Host: h})
+}
+
+// addUnavailableHost marks h unavailable since t. If li.unavailableHosts would exceed MAX_UNAVAILABLE_HOSTS, the
+// hosts marked the longest ago are made available again.
+func addUnavailableHost(li *ClusterLoadInfo, h string, t int64) {
+	li.unavailableHosts[h] = t
+	if li.awayCounts == nil {
+		li.awayCounts = make(map[string]int)
+	}
+	if li.reconnectDelays == nil {
+		li.reconnectDelays = make(map[string]int64)
+	}
+	li.awayCounts[h]++
+	li.reconnectDelays[h] = reconnectDelaySecs(li.config.failedHostReconnectDelaySecs, li.awayCounts[h])
+	for len(li.unavailableHosts) > MAX_UNAVAILABLE_HOSTS {
+		oldest := ""
+		for uh, ut := range li.unavailableHosts {
+			if oldest == "" || ut < li.unavailableHosts[oldest] {
+				oldest = uh
+			}
+		}
+		lbLogf(li.config, LBLogLevelWarn, "More than %d unavailable hosts, evicting %s marked at %s",
+			MAX_UNAVAILABLE_HOSTS, oldest, time.Unix(li.unavailableHosts[oldest], 0).Format(time.RFC3339))
+		delete(li.unavailableHosts, oldest)
+		delete(li.reconnectDelays, oldest)
+	}
+}
+
+// reconnectJitter returns the random jitter in [0, n) added by reconnectDelaySecs, it is replaced by tests.
+var reconnectJitter = mathrand.Int63n
+
+// reconnectDelaySecs returns the seconds a host stays unavailable after being marked the away-th time in a row: base,
+// doubled for every previous time, plus up to FAILED_HOST_RECONNECT_JITTER of it at random, at most
+// MAX_FAILED_HOST_RECONNECT_DELAY_SECS.
+func reconnectDelaySecs(base int64, away int) int64 {
+	delay := base
+	for i := 1; i < away && delay < MAX_FAILED_HOST_RECONNECT_DELAY_SECS; i++ {
+		delay *= 2
+	}
+	if delay > 0 {
+		delay += reconnectJitter(int64(float64(delay)*FAILED_HOST_RECONNECT_JITTER) + 1)
+	}
+	if delay > MAX_FAILED_HOST_RECONNECT_DELAY_SECS {
+		delay = MAX_FAILED_HOST_RECONNECT_DELAY_SECS
+	}
+	return delay
+}
+
+// reconnectDelay returns the seconds the host h marked unavailable stays so.
+func reconnectDelay(li *ClusterLoadInfo, h string) int64 {
+	if delay, ok := li.reconnectDelays[h]; ok {
+		return delay
+	}
+	return li.config.failedHostReconnectDelaySecs
+}
+
+// controlContext returns the context to create the control connection and query it with. It is derived from the
+// context of the connect triggering the refresh, so that cancelling the latter, e.g. on shutdown, aborts the refresh.
+// The returned cancel function must be called once the control connection is no longer used with the context.
+func controlContext(li *ClusterLoadInfo) (context.Context, context.CancelFunc) {
+	parent := li.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	return context.WithTimeout(parent, li.config.ControlConnTimeout)
+}
+
+func refreshLoadInfo(li *ClusterLoadInfo) (err error) {
+	if tracer, ok := li.config.Tracer.(LBRefreshTracer); ok {
+		ctx := tracer.TraceLBRefreshStart(li.ctx, TraceLBRefreshStartData{ClusterName: li.clusterName})
+		defer func() {
+			data := TraceLBRefreshEndData{ControlHost: li.config.controlHost, Err: err}
+			if err == nil {
+				for h := range li.hostPort {
+					data.Servers = append(data.Servers, h)
+				}
+				sort.Strings(data.Servers)
+			}
+			tracer.TraceLBRefreshEnd(ctx, data)
+		}()
+	}
+	var cancel context.CancelFunc
+	li.ctrlCtx, cancel = controlContext(li)
+	// cancel is replaced when the control connection is retried on another server.
+	defer func() { cancel() }()
+	if li.config.controlConns > 1 {
+		checkControlConn(li)
+	}
+	if li.controlConn == nil || li.controlConn.IsClosed() {
+		connectStart := time.Now()
+		var err error
+		// The config is copied as it may still be the one of the connect which created the load information.
+		li.config = li.config.Copy()
+		li.config.Host = LookupIP(li.config.Host)
+		li.config.ConnectTimeout = li.config.ControlConnTimeout
+		if li.config.controlDatabase != "" {
+			li.config.Database = li.config.controlDatabase
+		}
+		li.controlConn, err = connect(li.ctrlCtx, li.config)
+		if err != nil && li.ctx.Err() != nil {
+			// The connect triggering the refresh was cancelled, the host is not to blame.
+			li.controlConn = nil
+			return err
+		}
+		if err != nil {
+			lbLogf(li.config, LBLogLevelWarn, "Could not create control connection to %s\n", li.config.Host)
+			// remove its hostLoad entry
+			markHostAway(li, li.config.Host)
+			li.controlConn = nil
+			// Attempt connection to other servers which are already fetched in cli.
+			if len(li.hostPairs) > 0 {
+				lbLogf(li.config, LBLogLevelWarn, "Attempting control connection to %d other servers ...\n", len(li.hostPairs))
+			}
+			for h := range li.hostPairs {
+				li.config = li.config.cloneWithHost(h, li.hostPort[h])
+				cancel()
+				li.ctrlCtx, cancel = controlContext(li)
+				if li.controlConn, err = connect(li.ctrlCtx, li.config); err == nil {
+					lbLogf(li.config, LBLogLevelInfo, "Created control connection to host %s", h)
+					break
+				}
+				if li.ctx.Err() != nil {
+					break
+				}
+				lbLogf(li.config, LBLogLevelWarn, "Could not create control connection to host %s", h)
+				markHostAway(li, li.config.Host)
+				li.controlConn = nil
+			}
+			if err != nil {
+				lbLogf(li.config, LBLogLevelError, "Failed to create control connection: %s", redactSecrets(err.Error()))
+				return err
+			}
+		}
+		if li.config.controlHost != li.config.Host || li.controlHostSince.IsZero() {
+			li.controlHostSince = time.Now()
+		}
+		li.config.controlHost = li.config.Host
+		emitLBEvent(li.config,
+			LBEvent{Type: LBEventControlConnChanged, ClusterName: li.clusterName, Host: li.config.controlHost})
+		if li.coldStart != nil {
+			li.coldStart.ControlConnect += time.Since(connectStart)
+		}
+	}
+	// defer li.controlConn.Close(li.ctrlCtx)
+
+	queryStart := time.Now()
+	queryCtx := li.ctrlCtx
+	if li.config.BeforeControlQuery != nil {
+		queryCtx = li.config.BeforeControlQuery(li.ctrlCtx, li.controlConn)
+	}
+	query := serversQuery(li)
+	rows, err := li.controlConn.Query(queryCtx, query)
+	if err != nil && li.controlConn.IsClosed() && queryCtx.Err() == nil {
+		// The server closed the connection after it was checked, reconnect once to the same host before giving up on it.
+		lbLogf(li.config, LBLogLevelWarn, "Control connection to %s was closed, reconnecting", li.config.controlHost)
+		if li.controlConn, err = connect(li.ctrlCtx, li.config); err == nil {
+			rows, err = li.controlConn.Query(queryCtx, query)
+		}
+	}
+	if err != nil && li.ctx.Err() != nil {
+		return err
+	}
+	if query == LB_QUERY && isUndefinedColumn(err) {
+		li.noUUIDColumn = true // an older server, the host is not to blame
+		return refreshLoadInfo(li)
+	}
+	if err != nil {
+		lbLogf(li.config, LBLogLevelError, "Could not query load information: %s", redactSecrets(err.Error()))
+		markHostAway(li, li.config.controlHost)
+		li.controlConn = nil
+		return refreshLoadInfo(li)
+	}
+	defer rows.Close()
+	var host, nodeType, cloud, region, zone, publicIP string
+	var port, numConns int
+	var replicationLag *int64
+	var uuid *string
+	newHostLoadPrimary := make(map[string]int)
+	newHostLoadRR := make(map[string]int)
+	newHostPort := make(map[string]uint16)
 	newZoneListPrimary := make(map[string][]string)
 	newZoneListRR := make(map[string][]string)
 	newHostPairs := make(map[string]string)
+	newReplicationLag := make(map[string]int64)
+	newHostUUIDs := make(map[string]string)
+	previousHosts := make(map[string]string, len(li.hostUUIDs))
+	for h, id := range li.hostUUIDs {
+		previousHosts[id] = h
+	}
+	renamed := make(map[string]string)
+	withStarKeys := usesRegionWildcard(li.config.topologyKeys)
 	if li.unavailableHosts == nil {
 		li.unavailableHosts = make(map[string]int64)
 	}
+	// Columns are matched by name, so that columns added to yb_servers() by newer versions are ignored.
+	columns := map[string]any{
+		"host": &host, "port": &port, "num_connections": &numConns, "node_type": &nodeType,
+		"cloud": &cloud, "region": &region, "zone": &zone, "public_ip": &publicIP,
+	}
+	dest := make([]any, len(rows.FieldDescriptions()))
+	for i, fd := range rows.FieldDescriptions() {
+		if d, ok := columns[fd.Name]; ok {
+			dest[i] = d
+			delete(columns, fd.Name)
+		} else if fd.Name == REPLICATION_LAG_COLUMN {
+			dest[i] = &replicationLag
+		} else if fd.Name == UUID_COLUMN {
+			dest[i] = &uuid
+		} else {
+			dest[i] = new(any)
+		}
+	}
+	for name := range columns {
+		// Not specific to the control host, so another host would not do better.
+		err := fmt.Errorf("yb_servers() returned no %s column", name)
+		lbLogf(li.config, LBLogLevelError, "Could not read load information: %s", redactSecrets(err.Error()))
+		return err
+	}
+	skippedRows := 0
 	for rows.Next() {
-		err := rows.Scan(&host, &port, &numConns, &nodeType, &cloud, &region, &zone, &publicIP)
-		if err != nil {
-			log.Err(err).Msgf("Could not read load information: %s", err.Error())
+		// Unlike rows.Scan, ScanRow does not close rows on error, so that the next rows can still be read.
+		err := ScanRow(li.controlConn.TypeMap(), rows.FieldDescriptions(), rows.RawValues(), dest...)
+		if err != nil && li.config.skipBadRows {
+			lbLogf(li.config, LBLogLevelWarn, "Skipping a server of yb_servers() which could not be read: %s",
+				redactSecrets(err.Error()))
+			skippedRows++
+		} else if err != nil {
+			lbLogf(li.config, LBLogLevelError, "Could not read load information: %s", redactSecrets(err.Error()))
 			markHostAway(li, li.config.controlHost)
 			li.controlConn = nil
 			return refreshLoadInfo(li)
 		} else {
+			class := classifyNodeType(nodeType)
+			if li.config.ClassifyNodeType != nil {
+				class = li.config.ClassifyNodeType(nodeType)
+			}
+			if class == NodeClassIgnore {
+				continue
+			}
+			if isUnixSocket(host) {
+				lbLogf(li.config, LBLogLevelWarn, "Skipping server %s of yb_servers(), a unix socket cannot be load balanced to",
+					host)
+				continue
+			}
 			host = LookupIP(host)
 			publicIP = LookupIP(publicIP)
 			newHostPairs[host] = publicIP
+			if replicationLag != nil {
+				newReplicationLag[host] = *replicationLag
+			}
+			counted := host // the host the connection count of the server is carried over from
+			if uuid != nil && *uuid != "" {
+				newHostUUIDs[host] = *uuid
+				if previous, ok := renamedFrom(li, previousHosts, *uuid, host); ok {
+					counted = previous
+					renamed[previous] = host
+				}
+			}
 			tk := cloud + "." + region + "." + zone
-			tk_star := cloud + "." + region // Used for topology_keys of type: cloud.region.*
-			if nodeType == "primary" {
+			tk_star := "" // Used for topology_keys of type: cloud.region.*
+			if withStarKeys {
+				tk_star = cloud + "." + region
+			}
+			if class == NodeClassPrimary {
 				setUpZoneList(newZoneListPrimary, tk, tk_star, host)
-				newHostLoadPrimary[host] = li.hostLoadPrimary[host]
+				newHostLoadPrimary[host] = hostLoad(li, li.hostLoadPrimary[counted], numConns)
 			} else {
 				setUpZoneList(newZoneListRR, tk, tk_star, host)
-				newHostLoadRR[host] = li.hostLoadRR[host]
+				newHostLoadRR[host] = hostLoad(li, li.hostLoadRR[counted], numConns)
 			}
 			newHostPort[host] = uint16(port)
 		}
 	}
 
-	rsError := rows.Err()
-	if rsError != nil {
-		log.Err(err).Msgf("refreshLoadInfo(): Could not read load information, Rows.Err(): %s", rsError.Error())
-		markHostAway(li, li.config.controlHost)
-		li.controlConn = nil
-		return refreshLoadInfo(li)
+	rsError := rows.Err()
+	if query == LB_QUERY && isUndefinedColumn(rsError) {
+		li.noUUIDColumn = true
+		return refreshLoadInfo(li)
+	}
+	if rsError != nil {
+		lbLogf(li.config, LBLogLevelError, "refreshLoadInfo(): Could not read load information, Rows.Err(): %s",
+			redactSecrets(rsError.Error()))
+		markHostAway(li, li.config.controlHost)
+		li.controlConn = nil
+		return refreshLoadInfo(li)
+	}
+	if skippedRows > 0 && len(newHostPort) == 0 {
+		// Committing no servers at all would lose the topology, keep the previous one as if the refresh failed.
+		err := fmt.Errorf("none of the %d servers of yb_servers() could be read", skippedRows)
+		lbLogf(li.config, LBLogLevelError, "Could not read load information: %s", err)
+		return err
+	}
+	li.skippedRows += uint64(skippedRows)
+	if li.coldStart != nil {
+		li.coldStart.ServersQuery += time.Since(queryStart)
+	}
+	warnSharedPublicIPs(li, newHostPairs)
+	if !sameHosts(li.hostPort, newHostPort) {
+		li.generation++
+	}
+	var previousTopology []TopologyNode
+	if li.config.OnTopologyChange != nil && !li.lastSuccessfulRefresh.IsZero() {
+		previousTopology = clusterTopology(li)
+	}
+	for previous, host := range renamed {
+		if _, listed := newHostPort[previous]; !listed {
+			renameHost(li, previous, host)
+		}
+	}
+	added, removed := hostsDelta(li.hostPort, newHostPort)
+	if !li.lastSuccessfulRefresh.IsZero() {
+		for _, h := range added {
+			if li.joinedAt == nil {
+				li.joinedAt = make(map[string]time.Time)
+			}
+			li.joinedAt[h] = time.Now()
+		}
+	}
+	for _, h := range removed {
+		delete(li.lastSelected, h)
+		delete(li.joinedAt, h)
+		delete(li.dataConnectFailures, h)
+		delete(li.asymmetricHosts, h)
+		delete(li.awayCounts, h)
+	}
+	li.hostPort = newHostPort
+	li.zoneListPrimary = newZoneListPrimary
+	li.zoneListRR = newZoneListRR
+	li.hostPairs = newHostPairs
+	li.hostLoadPrimary = newHostLoadPrimary
+	li.hostLoadRR = newHostLoadRR
+	li.replicationLag = newReplicationLag
+	li.hostUUIDs = newHostUUIDs
+	pruneRenamedHosts(li)
+	li.lastRefresh = time.Now()
+	li.lastSuccessfulRefresh = li.lastRefresh
+	emitLBEvent(li.config, LBEvent{Type: LBEventRefreshed, ClusterName: li.clusterName, Added: added, Removed: removed})
+	recoverUnavailableHosts(li)
+	if previousTopology != nil {
+		notifyTopologyChange(li, previousTopology)
+	}
+	if li.config.tabletAware && !li.noTabletMetadata {
+		refreshTabletLeaders(li)
+	}
+	warnUnmatchableTopologyKeys(li)
+	if li.config.controlConns > 1 || len(li.standbyControlConns) > 0 {
+		fillStandbyControlConns(li)
+	}
+	return nil
+}
+
+// recoverUnavailableHosts makes the hosts marked unavailable for longer than their reconnectDelay available again.
+func recoverUnavailableHosts(li *ClusterLoadInfo) {
+	expireDrainedHosts(li)
+	if li.config.probeInterval > 0 {
+		return // the hosts are made available by the prober once they respond
+	}
+	for uh, t := range li.unavailableHosts {
+		if time.Now().Unix()-t > reconnectDelay(li, uh) {
+			// clear the unavailable-hosts list
+			lbLogf(li.config, LBLogLevelInfo, "Removing %s from unavailableHosts Map", uh)
+			restoreHost(li, uh)
+		}
+	}
+}
+
+// restoreHost makes the host uh marked unavailable available again, with no connections counted against it.
+func restoreHost(li *ClusterLoadInfo, uh string) {
+	if _, found := li.hostLoadPrimary[uh]; found {
+		li.hostLoadPrimary[uh] = 0
+	} else if _, found = li.hostLoadRR[uh]; found {
+		li.hostLoadRR[uh] = 0
+	}
+	delete(li.unavailableHosts, uh)
+	delete(li.reconnectDelays, uh)
+	// The connections to a host available again are kept, even if it became available before its quarantine ended.
+	delete(li.quarantinedUntil, uh)
+	if public := li.hostPairs[uh]; public != "" {
+		delete(li.quarantinedUntil, public)
+	}
+	emitLBEvent(li.config, LBEvent{Type: LBEventHostRecovered, ClusterName: li.clusterName, Host: uh})
+}
+
+// warnUnmatchableTopologyKeys logs a warning for every topology key which only has servers the load balancing mode
+// excludes, like a zone with only primaries with load_balance=only-rr.
+func warnUnmatchableTopologyKeys(li *ClusterLoadInfo) {
+	var allowed, excluded map[string][]string
+	var excludedType string
+	switch li.config.loadBalance {
+	case "only-rr":
+		allowed, excluded, excludedType = li.zoneListRR, li.zoneListPrimary, "primary"
+	case "only-primary":
+		allowed, excluded, excludedType = li.zoneListPrimary, li.zoneListRR, "read replica"
+	default:
+		return
+	}
+	for _, tks := range li.config.topologyKeys {
+		for _, tk := range tks {
+			if len(zoneHosts(allowed, tk)) == 0 && len(zoneHosts(excluded, tk)) > 0 {
+				lbLogf(li.config, LBLogLevelWarn, "Topology key %s can never match with load_balance=%s, it only has %s servers",
+					tk, li.config.loadBalance, excludedType)
+			}
+		}
+	}
+}
+
+// serversQuery returns the query listing the servers of the cluster: yb_servers_query if set, LB_QUERY_WITH_LAG if the
+// replication lag of the servers is needed, LB_QUERY otherwise, or LB_QUERY_WITHOUT_UUID if the cluster does not report
+// the UUID_COLUMN.
+func serversQuery(li *ClusterLoadInfo) string {
+	if li.config.serversQuery != "" {
+		return li.config.serversQuery
+	}
+	if li.config.maxReplicaLagMs > 0 {
+		return LB_QUERY_WITH_LAG
+	}
+	if li.noUUIDColumn {
+		return LB_QUERY_WITHOUT_UUID
+	}
+	return LB_QUERY
+}
+
+// hostLoad returns the connection count of a server after a refresh, given the count tracked by the driver and the
+// num_connections the server reports. With load_balance_load_source=server, or yb_servers_refresh_load=true, the
+// latter is taken as is, so that the connections of other client processes and of the ones before a restart of the
+// client are accounted for, and the driver only adds its own connections until the next refresh.
+func hostLoad(li *ClusterLoadInfo, count int, numConns int) int {
+	if li.config.serverLoad {
+		return numConns
+	}
+	return decayCount(count, numConns, li.config.countDecayFraction)
+}
+
+// decayCount moves the driver-tracked count of a host by fraction of its distance to the num_connections the server
+// reports. The server also counts connections of other clients, so only a count above it is known to have drifted.
+func decayCount(count int, numConns int, fraction float64) int {
+	if fraction <= 0 || count <= numConns {
+		return count
+	}
+	return count - int(math.Ceil(float64(count-numConns)*fraction))
+}
+
+// warnSharedPublicIPs logs a warning for every public address shared by several servers, e.g. behind a NAT. Connections
+// using such an address are spread over its servers by the NAT, not by the load balancer.
+func warnSharedPublicIPs(li *ClusterLoadInfo, hostPairs map[string]string) {
+	privateHosts := make(map[string][]string)
+	for private, public := range hostPairs {
+		if public != "" {
+			privateHosts[public] = append(privateHosts[public], private)
+		}
+	}
+	for public, hosts := range privateHosts {
+		if len(hosts) > 1 {
+			sort.Strings(hosts)
+			lbLogf(li.config, LBLogLevelWarn,
+				"Public IP %s is shared by hosts %s, connections to it cannot be balanced between them", public,
+				strings.Join(hosts, ","))
+		}
+	}
+}
+
+func sameHosts(a map[string]uint16, b map[string]uint16) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	li.hostPort = newHostPort
-	li.zoneListPrimary = newZoneListPrimary
-	li.zoneListRR = newZoneListRR
-	li.hostPairs = newHostPairs
-	li.hostLoadPrimary = newHostLoadPrimary
-	li.hostLoadRR = newHostLoadRR
-	li.lastRefresh = time.Now()
-	for uh, t := range li.unavailableHosts {
-		if time.Now().Unix()-t > li.config.failedHostReconnectDelaySecs {
-			// clear the unavailable-hosts list
-			log.Info().Msgf("Removing %s from unavailableHosts Map", uh)
-			if _, found := li.hostLoadPrimary[uh]; found {
-				li.hostLoadPrimary[uh] = 0
-			} else if _, found = li.hostLoadRR[uh]; found {
-				li.hostLoadRR[uh] = 0
-			}
-			delete(li.unavailableHosts, uh)
+	for h := range a {
+		if _, ok := b[h]; !ok {
+			return false
 		}
 	}
-	return nil
+	return true
 }
 
+// setUpZoneList adds host to the zone tk and, if tk_star is not empty, to the region tk_star.
 func setUpZoneList(zoneList map[string][]string, tk string, tk_star string, host string) {
 	hosts, ok := zoneList[tk]
 	if !ok {
 		hosts = make([]string, 0)
 	}
+	hosts = append(hosts, host)
+	zoneList[tk] = hosts
+	if tk_star == "" {
+		return
+	}
 	hosts_star, ok_star := zoneList[tk_star]
 	if !ok_star {
 		hosts_star = make([]string, 0)
 	}
-	hosts = append(hosts, host)
 	hosts_star = append(hosts_star, host)
-	zoneList[tk] = hosts
 	zoneList[tk_star] = hosts_star
 }
 
-func getHostWithLeastConns(li *ClusterLoadInfo) *lbHost {
-	leastCnt := int(math.MaxInt32)
+// usesRegionWildcard tells if any of the topology keys is of type cloud.region.*
+func usesRegionWildcard(topologyKeys map[int][]string) bool {
+	for _, tks := range topologyKeys {
+		for _, tk := range tks {
+			if strings.HasSuffix(tk, ".*") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostsInRegion returns the hosts of all zones of the region tk_star ("cloud.region"). It is used when the zone list
+// was set up without star-keys because no topology key was of type cloud.region.* at that time.
+func hostsInRegion(zoneList map[string][]string, tk_star string) []string {
+	var hosts []string
+	for tk, zoneHosts := range zoneList {
+		if strings.HasPrefix(tk, tk_star+".") {
+			hosts = append(hosts, zoneHosts...)
+		}
+	}
+	return hosts
+}
+
+// zoneHosts returns the hosts of zoneList matching the topology key tk, which may be of type cloud.region.*.
+func zoneHosts(zoneList map[string][]string, tk string) []string {
+	if strings.HasSuffix(tk, ".*") {
+		region := strings.TrimSuffix(tk, ".*")
+		if hosts, ok := zoneList[region]; ok {
+			return hosts
+		}
+		return hostsInRegion(zoneList, region)
+	}
+	return zoneList[tk]
+}
+
+func getHostWithLeastConns(li *ClusterLoadInfo) (selected *lbHost) {
+	if pref, ok := NodePreferenceFromContext(li.ctx); ok && li.config.loadBalance != pref.loadBalance() {
+		// The servers are selected as for the preference, without changing the settings of the cluster.
+		shared := li.config
+		preferred := *shared
+		preferred.loadBalance = pref.loadBalance()
+		li.config = &preferred
+		defer func() { li.config = shared }()
+	}
+	var candidates []string
+	if tracer, ok := li.config.Tracer.(LBHostSelectTracer); ok {
+		ctx := tracer.TraceLBHostSelectStart(li.ctx, TraceLBHostSelectStartData{ClusterName: li.clusterName})
+		defer func() {
+			tracer.TraceLBHostSelectEnd(ctx, TraceLBHostSelectEndData{
+				Host:             selected.hostname,
+				Port:             selected.port,
+				NodeType:         selected.nodeType,
+				TopologyTier:     selected.topologyTier,
+				TopologyFallback: selected.err == nil && li.config.topologyKeys != nil && selected.topologyTier == -1,
+				FallbackLevel:    selected.fallbackLevel,
+				Candidates:       candidates,
+				Err:              selected.err,
+			})
+		}()
+	}
+
 	leastLoaded := ""
-	leastLoadedservers := make([]string, 0)
+	hostload, eligible, topologyTier, fallbackLevel, err := eligibleHosts(li, true)
+	if err == ErrFallbackToOriginalBehaviour {
+		atomic.AddUint64(&li.config.clusterManager().topologyKeysStrictFallbacks, 1)
+	}
+	if err != nil {
+		return &lbHost{err: err}
+	}
+	candidates = distinctHosts(eligible)
+	if n := countDistinct(eligible); n > 0 && n < li.config.minEligibleHosts {
+		lbLogf(li.config, LBLogLevelWarn, "Only %d eligible servers, fewer than load_balance_min_eligible_hosts=%d", n,
+			li.config.minEligibleHosts)
+		return &lbHost{err: ErrTooFewEligibleHosts}
+	}
+	leastCnt, leastLoadedservers := leastLoadedOf(hostload, slowStartHosts(li, hostload, eligible, topologyTier))
+	if key, ok := li.ctx.Value(routingKeyCtxKey{}).(string); ok {
+		routed := routedHost(key, eligible)
+		leastCnt, leastLoadedservers = hostload[routed], []string{routed}
+	} else if leader := tabletLeader(li, eligible); leader != "" {
+		leastCnt, leastLoadedservers = hostload[leader], []string{leader}
+	} else if priority := priorityHost(li, eligible); priority != "" {
+		leastCnt, leastLoadedservers = hostload[priority], []string{priority}
+	} else if li.config.latencyAware {
+		if fast := lowLatencyHosts(li, eligible); len(fast) != 0 {
+			leastCnt, leastLoadedservers = leastLoadedOf(hostload, fast)
+		}
+	} else if li.config.affinityTolerance > 0 {
+		if warm := warmHosts(li, hostload, eligible, leastCnt+li.config.affinityTolerance); len(warm) != 0 {
+			leastCnt, leastLoadedservers = leastLoadedOf(hostload, warm)
+		}
+	}
+	if local := localHosts(li, leastLoadedservers); len(local) != 0 {
+		leastLoadedservers = local
+	}
+
+	if len(leastLoadedservers) != 0 {
+		randomIndex, err := rand.Int(rand.Reader, big.NewInt(int64(len(leastLoadedservers))))
+		if err != nil {
+			lbLogf(li.config, LBLogLevelError, "Could not select a leastloadedserver randomly: %s", err)
+		}
+		leastLoaded = leastLoadedservers[randomIndex.Int64()]
+	}
+
+	if leastLoaded == "" {
+		if li.flags == TRY_HOSTS_PUBLIC_IP {
+			// remove all host (private ips) from unavailable list
+			for h := range li.hostPairs {
+				delete(li.unavailableHosts, h)
+			}
+			li.flags = HOSTS_EXHAUSTED
+			emitLBEvent(li.config, LBEvent{Type: LBEventFlagsChanged, ClusterName: li.clusterName, Flags: li.flags})
+			return getHostWithLeastConns(li)
+		}
+		lbh := &lbHost{
+			hostname: "",
+			err:      noServersAvailable(li, false),
+		}
+		lbLogf(li.config, LBLogLevelWarn, "No hosts found: %s", lbh.err)
+		return lbh
+	}
+	leastLoadedToUse := leastLoaded
+	usePublicIP := li.flags == USE_PUBLIC_IP || li.flags == HOSTS_EXHAUSTED
+	if addressType, ok := li.ctx.Value(addressTypeCtxKey{}).(byte); ok {
+		usePublicIP = addressType == USE_PUBLIC_IP
+	}
+	if usePublicIP {
+		leastLoadedToUse = li.hostPairs[leastLoaded]
+		if leastLoadedToUse == "" {
+			lbh := &lbHost{
+				hostname: "",
+				err:      noServersAvailable(li, true),
+			}
+			lbLogf(li.config, LBLogLevelWarn, "No hosts and public ip found: %s", lbh.err)
+			return lbh
+		}
+	}
+	nodeType := "read_replica"
+	if _, found := li.hostLoadPrimary[leastLoaded]; found {
+		nodeType = "primary"
+	}
+	lbh := &lbHost{
+		hostname:      leastLoadedToUse,
+		port:          li.hostPort[leastLoaded],
+		countedHost:   leastLoaded,
+		nodeType:      nodeType,
+		placement:     hostPlacement(li, leastLoaded),
+		controlHost:   li.config.controlHost,
+		topologyTier:  topologyTier,
+		fallbackLevel: fallbackLevel,
+		generation:    li.generation,
+		err:           nil,
+	}
+	recordSelection(li, leastLoadedToUse)
+	recordFallbackLevel(li, fallbackLevel)
+	if li.lastSelected == nil {
+		li.lastSelected = make(map[string]time.Time)
+	}
+	li.lastSelected[leastLoaded] = time.Now()
+	// The count is kept against the private address even if the public one is used, since several servers may share a
+	// public address.
+	if cnt, found := li.hostLoadPrimary[leastLoaded]; found {
+		li.hostLoadPrimary[leastLoaded] = cnt + 1
+	} else {
+		li.hostLoadRR[leastLoaded] = leastCnt + 1
+	}
+	return lbh
+}
+
+func recordSelection(li *ClusterLoadInfo, host string) {
+	if li.selectionCounts == nil {
+		li.selectionCounts = make(map[string]int)
+	}
+	li.selectionCounts[host]++
+	window := li.config.selectionWindow
+	if window <= 0 {
+		li.recentSelections = nil
+		return
+	}
+	if cap(li.recentSelections) != window {
+		li.recentSelections = make([]string, 0, window)
+		li.recentSelectionsNext = 0
+	}
+	if len(li.recentSelections) < window {
+		li.recentSelections = append(li.recentSelections, host)
+	} else {
+		li.recentSelections[li.recentSelectionsNext] = host
+		li.recentSelectionsNext = (li.recentSelectionsNext + 1) % window
+	}
+}
+
+// SelectionDistribution returns the share of each host among the last selections made for the cluster connString
+// belongs to. The number of selections considered is set with the load_balance_selection_window parameter, nothing
+// is tracked if it is not set. The shares add up to 1. It returns ErrNoLoadInfo if no load balanced connection has been
+// made to that cluster with the default ClusterManager yet.
+func SelectionDistribution(connString string) (map[string]float64, error) {
+	return defaultClusterManager.SelectionDistribution(connString)
+}
+
+// SelectionDistribution returns the share of each host among the last selections made by m for the cluster connString
+// belongs to, see SelectionDistribution.
+func (m *ClusterManager) SelectionDistribution(connString string) (map[string]float64, error) {
+	dist := make(map[string]float64)
+	err := m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		for _, h := range li.recentSelections {
+			dist[h]++
+		}
+		for h := range dist {
+			dist[h] /= float64(len(li.recentSelections))
+		}
+		return nil
+	})
+	return dist, err
+}
+
+// SelectionCounts returns the number of times each host was selected for a connection to the cluster connString
+// belongs to, since the first connect to it or the last ResetSelectionCounts. Unlike connection counts, selections are
+// never decremented. It returns ErrNoLoadInfo if no load balanced connection has been made to that cluster with the
+// default ClusterManager yet.
+func SelectionCounts(connString string) (map[string]int, error) {
+	return defaultClusterManager.SelectionCounts(connString)
+}
+
+// SelectionCounts returns the number of times m selected each host for a connection to the cluster connString belongs
+// to, see SelectionCounts.
+func (m *ClusterManager) SelectionCounts(connString string) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		for h, n := range li.selectionCounts {
+			counts[h] = n
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// ResetSelectionCounts sets the selection counts of the cluster connString belongs to back to zero. It returns
+// ErrNoLoadInfo if no load balanced connection has been made to that cluster with the default ClusterManager yet.
+func ResetSelectionCounts(connString string) error {
+	return defaultClusterManager.ResetSelectionCounts(connString)
+}
+
+// ResetSelectionCounts sets the selection counts of the cluster connString belongs to as known by m back to zero, see
+// ResetSelectionCounts.
+func (m *ClusterManager) ResetSelectionCounts(connString string) error {
+	return m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		li.selectionCounts = nil
+		return nil
+	})
+}
+
+// eligibleHosts returns the hosts the least loaded host is selected from, along with the connection counts of the
+// hosts, the index of the topology_keys preference they match, -1 if they were not selected by topology_keys, and the
+// level of the fallback ladder they were selected from otherwise. It returns ErrFallbackToOriginalBehaviour if no host
+// matches topology_keys and fallback_to_topology_keys_only is set. selecting is true when a server is selected from
+// the hosts, the copies of the load information then being recorded in SelectionCopyStats.
+func eligibleHosts(li *ClusterLoadInfo, selecting bool) (hostload map[string]int, hosts []string, topologyTier int,
+	level FallbackLevel, err error) {
+	copyStats := selecting && atomic.LoadInt32(&selectionCopyStatsEnabled) != 0
+	var copyStart time.Time
+	if copyStats {
+		copyStart = time.Now()
+	}
 	zonelist := make(map[string][]string)
-	hostload := make(map[string]int)
+	hostload = make(map[string]int)
 	if li.config.loadBalance == "only-rr" || li.config.loadBalance == "prefer-rr" {
 		maps.Copy(zonelist, li.zoneListRR)
 		maps.Copy(hostload, li.hostLoadRR)
@@ -488,131 +2497,289 @@ func getHostWithLeastConns(li *ClusterLoadInfo) *lbHost {
 		}
 		maps.Copy(hostload, li.hostLoadPrimary)
 	}
+	if copyStats {
+		recordSelectionCopy(time.Since(copyStart), len(zonelist), len(hostload))
+	}
 	if li.config.topologyKeys != nil {
-		for i := 0; i < len(li.config.topologyKeys); i++ {
+		for _, i := range topologyTiers(li.config.topologyKeys) {
 			var servers []string
 			for _, tk := range li.config.topologyKeys[i] {
-				toCheckStar := strings.Split(tk, ".")
-				if toCheckStar[2] == "*" {
-					tk = toCheckStar[0] + "." + toCheckStar[1]
-				}
-				servers = append(servers, zonelist[tk]...)
-			}
-			for _, h := range servers {
-				if !isHostAway(li, h) {
-					if hostload[h] < leastCnt {
-						leastLoadedservers = nil
-						leastLoadedservers = append(leastLoadedservers, h)
-						leastCnt = hostload[h]
-					} else if hostload[h] == leastCnt {
-						leastLoadedservers = append(leastLoadedservers, h)
+				if li.config.intraTierOrdered {
+					// The keys of a tier are preferred in the order they are listed.
+					if hosts = usableHosts(li, topologyKeyHosts(zonelist, tk)); len(hosts) != 0 {
+						return hostload, hosts, i, FallbackLevelNone, nil
 					}
+					continue
 				}
+				servers = append(servers, topologyKeyHosts(zonelist, tk)...)
 			}
-			if leastCnt != int(math.MaxInt32) && len(leastLoadedservers) != 0 {
-				break
+			if hosts = usableHosts(li, servers); len(hosts) != 0 {
+				return hostload, hosts, i, FallbackLevelNone, nil
 			}
 		}
-	}
-	if leastCnt == int(math.MaxInt32) && len(leastLoadedservers) == 0 {
-		if !(li.config.loadBalance == "prefer-primary" || li.config.loadBalance == "prefer-rr") {
-			if li.config.topologyKeys == nil || !li.config.fallbackToTopologyKeysOnly {
-				leastCnt, leastLoadedservers = getHosts(li, hostload)
-			} else {
-				lbh := &lbHost{
-					err: ErrFallbackToOriginalBehaviour,
+		if li.config.fallbackLadder && !li.config.fallbackToTopologyKeysOnly {
+			for _, level := range []FallbackLevel{FallbackLevelRegion, FallbackLevelCloud} {
+				servers := fallbackLadderHosts(zonelist, li.config.topologyKeys, level)
+				if hosts = usableHosts(li, servers); len(hosts) != 0 {
+					return hostload, hosts, -1, level, nil
 				}
-				return lbh
 			}
-		} else {
-			leastCnt, leastLoadedservers = getHosts(li, hostload)
-			if leastCnt == int(math.MaxInt32) && len(leastLoadedservers) == 0 {
-				if li.config.loadBalance == "prefer-rr" {
-					leastCnt, leastLoadedservers = getHosts(li, li.hostLoadPrimary)
-				} else {
-					leastCnt, leastLoadedservers = getHosts(li, li.hostLoadRR)
-				}
+		}
+		level = FallbackLevelAll
+	}
+	if !(li.config.loadBalance == "prefer-primary" || li.config.loadBalance == "prefer-rr") {
+		if li.config.topologyKeys != nil && li.config.fallbackToTopologyKeysOnly {
+			return nil, nil, -1, level, ErrFallbackToOriginalBehaviour
+		}
+		return hostload, availableHosts(li, hostload), -1, level, nil
+	}
+	if hosts = availableHosts(li, hostload); len(hosts) != 0 {
+		return hostload, hosts, -1, level, nil
+	}
+	if li.config.loadBalance == "prefer-rr" {
+		return li.hostLoadPrimary, availableHosts(li, li.hostLoadPrimary), -1, level, nil
+	}
+	return li.hostLoadRR, availableHosts(li, li.hostLoadRR), -1, level, nil
+}
+
+// topologyKeyHosts returns the hosts of zonelist matching the topology key tk.
+func topologyKeyHosts(zonelist map[string][]string, tk string) []string {
+	toCheckStar := strings.Split(tk, ".")
+	if toCheckStar[2] == "*" {
+		tk = toCheckStar[0] + "." + toCheckStar[1]
+		if _, ok := zonelist[tk]; !ok {
+			return hostsInRegion(zonelist, tk)
+		}
+	}
+	return zonelist[tk]
+}
+
+// usableHosts returns the hosts of servers which are neither marked away, excluded by config.allowHosts, lagging nor
+// full.
+func usableHosts(li *ClusterLoadInfo, servers []string) []string {
+	var hosts []string
+	for _, h := range servers {
+		if !isHostAway(li, h) && isHostAllowed(li, h) && !isHostLagging(li, h) && !isHostFull(li, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// priorityHost returns the first host of config.priorityHosts, given by its private or public address, which is one of
+// hosts. It returns "" if there is none.
+func priorityHost(li *ClusterLoadInfo, hosts []string) string {
+	for _, p := range li.config.priorityHosts {
+		for _, h := range hosts {
+			if h == p || li.hostPairs[h] == p {
+				return h
 			}
 		}
 	}
+	return ""
+}
 
-	if len(leastLoadedservers) != 0 {
-		randomIndex, err := rand.Int(rand.Reader, big.NewInt(int64(len(leastLoadedservers))))
+// routedHost returns the host of hosts key hashes to. Every host is scored by the hash of the key and the host and the
+// highest score wins, so that removing a host only moves the keys which hashed to it.
+func routedHost(key string, hosts []string) string {
+	var routed string
+	var best uint64
+	for _, h := range hosts {
+		hash := fnv.New64a()
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(h))
+		if score := hash.Sum64(); routed == "" || score > best {
+			routed, best = h, score
+		}
+	}
+	return routed
+}
+
+// localHosts returns the hosts of hosts which are, by their private or public address, one of config.localAddresses.
+func localHosts(li *ClusterLoadInfo, hosts []string) []string {
+	var local []string
+	for _, h := range hosts {
+		if li.config.localAddresses[h] || li.config.localAddresses[li.hostPairs[h]] {
+			local = append(local, h)
+		}
+	}
+	return local
+}
+
+var interfaceAddresses struct {
+	sync.Once
+	addrs map[string]bool
+}
+
+// localInterfaceAddresses returns the addresses of the network interfaces of the client, which are looked up once.
+func localInterfaceAddresses() map[string]bool {
+	interfaceAddresses.Do(func() {
+		interfaceAddresses.addrs = make(map[string]bool)
+		addrs, err := net.InterfaceAddrs()
 		if err != nil {
-			log.Err(err).Msg("Could not select a leastloadedserver randomly")
+			lbLogf(nil, LBLogLevelWarn, "Could not list the addresses of the network interfaces: %s", err)
+			return
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				interfaceAddresses.addrs[ipNet.IP.String()] = true
+			}
+		}
+	})
+	return interfaceAddresses.addrs
+}
+
+// warmHosts returns the hosts of hosts selected within AFFINITY_WINDOW which have at most maxCnt connections.
+func warmHosts(li *ClusterLoadInfo, hostLoad map[string]int, hosts []string, maxCnt int) []string {
+	var warm []string
+	for _, h := range hosts {
+		if t, ok := li.lastSelected[h]; ok && time.Since(t) < AFFINITY_WINDOW && hostLoad[h] <= maxCnt {
+			warm = append(warm, h)
 		}
-		leastLoaded = leastLoadedservers[randomIndex.Int64()]
 	}
+	return warm
+}
 
-	if leastLoaded == "" {
-		if li.flags == TRY_HOSTS_PUBLIC_IP {
-			// remove all host (private ips) from unavailable list
-			for h := range li.hostPairs {
-				delete(li.unavailableHosts, h)
+// slowStartHosts returns the hosts of hosts without the ones ramping up after they joined the cluster which already
+// have their share of connections. A host which joined a fraction f of its ramp ago is kept while it has fewer than f
+// times the connections of the least loaded of the other hosts, plus one, so that it gets about f times their share.
+// The ramp is config.slowStart multiplied by config.slowStartTierFactor once for every topology_keys preference before
+// topologyTier, the one hosts were selected from, so that the servers of the preferred zones ramp up faster than the
+// ones of the zones fallen back to.
+func slowStartHosts(li *ClusterLoadInfo, hostLoad map[string]int, hosts []string, topologyTier int) []string {
+	if li.config.slowStart <= 0 || len(li.joinedAt) == 0 {
+		return hosts
+	}
+	ramp := slowStartRamp(li.config, topologyTier)
+	ramping := make(map[string]float64)
+	settledCnt := -1
+	for _, h := range hosts {
+		if joined, ok := li.joinedAt[h]; ok {
+			if elapsed := time.Since(joined); elapsed < ramp {
+				ramping[h] = float64(elapsed) / float64(ramp)
+				continue
 			}
-			li.flags = HOSTS_EXHAUSTED
-			return getHostWithLeastConns(li)
 		}
-		lbh := &lbHost{
-			hostname: "",
-			err:      errors.New(NO_SERVERS_MSG),
+		if settledCnt == -1 || hostLoad[h] < settledCnt {
+			settledCnt = hostLoad[h]
 		}
-		log.Warn().Msg("No hosts found, returning with NO_SERVERS_MSG")
-		return lbh
 	}
-	leastLoadedToUse := leastLoaded
-	if li.flags == USE_PUBLIC_IP || li.flags == HOSTS_EXHAUSTED {
-		leastLoadedToUse = li.hostPairs[leastLoaded]
-		if leastLoadedToUse == "" {
-			lbh := &lbHost{
-				hostname: "",
-				err:      errors.New(NO_SERVERS_MSG),
+	if len(ramping) == 0 || settledCnt == -1 {
+		// Hosts ramping up alone get all the connections.
+		return hosts
+	}
+	kept := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if f, ok := ramping[h]; !ok || float64(hostLoad[h]) < f*float64(settledCnt+1) {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
+
+// slowStartRamp returns the time over which the hosts selected from the topology_keys preference topologyTier, -1 for
+// the hosts of the rest of the cluster, ramp up after they joined the cluster.
+func slowStartRamp(config *ConnConfig, topologyTier int) time.Duration {
+	position := 0
+	if config.topologyKeys != nil {
+		tiers := topologyTiers(config.topologyKeys)
+		position = len(tiers)
+		for i, tier := range tiers {
+			if tier == topologyTier {
+				position = i
 			}
-			log.Warn().Msg("No hosts and public ip found, returning with NO_SERVERS_MSG")
-			return lbh
 		}
 	}
-	lbh := &lbHost{
-		hostname: leastLoadedToUse,
-		port:     li.hostPort[leastLoaded],
-		err:      nil,
+	ramp := float64(config.slowStart) * math.Pow(config.slowStartTierFactor, float64(position))
+	if ramp >= math.MaxInt64 {
+		return math.MaxInt64
 	}
-	if leastLoaded == leastLoadedToUse {
-		if cnt, found := li.hostLoadPrimary[leastLoadedToUse]; found {
-			li.hostLoadPrimary[leastLoadedToUse] = cnt + 1
-		} else {
-			li.hostLoadRR[leastLoadedToUse] = leastCnt + 1
+	return time.Duration(ramp)
+}
+
+// lowLatencyHosts returns the hosts of hosts whose average connect latency is at most LATENCY_AWARE_TOLERANCE times
+// the lowest one, along with the hosts no connect was made to yet so that their latency gets known.
+func lowLatencyHosts(li *ClusterLoadInfo, hosts []string) []string {
+	latencies := make(map[string]time.Duration, len(hosts))
+	var lowest time.Duration
+	for _, h := range hosts {
+		latency, ok := li.latencyAverages[h]
+		if public := li.hostPairs[h]; !ok && public != "" {
+			latency, ok = li.latencyAverages[public]
 		}
-	} else {
-		_, foundpublic := li.hostLoadPrimary[leastLoadedToUse]
-		_, foundprivate := li.hostLoadPrimary[leastLoaded]
-		if foundpublic || foundprivate {
-			li.hostLoadPrimary[leastLoadedToUse] = leastCnt + 1
-		} else {
-			li.hostLoadRR[leastLoadedToUse] = leastCnt + 1
+		if !ok {
+			continue
+		}
+		latencies[h] = latency
+		if lowest == 0 || latency < lowest {
+			lowest = latency
 		}
 	}
-	return lbh
+	var fast []string
+	for _, h := range hosts {
+		if latency, ok := latencies[h]; !ok || float64(latency) <= float64(lowest)*LATENCY_AWARE_TOLERANCE {
+			fast = append(fast, h)
+		}
+	}
+	return fast
+}
+
+// availableHosts returns the hosts of hostLoad which are neither marked away, excluded by config.allowHosts, lagging
+// nor full.
+func availableHosts(li *ClusterLoadInfo, hostLoad map[string]int) []string {
+	var hosts []string
+	for h := range hostLoad {
+		if !isHostAway(li, h) && isHostAllowed(li, h) && !isHostLagging(li, h) && !isHostFull(li, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// countDistinct returns the number of distinct hosts in hosts.
+func countDistinct(hosts []string) int {
+	distinct := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		distinct[h] = true
+	}
+	return len(distinct)
+}
+
+// distinctHosts returns the hosts of hosts once each, in lexical order.
+func distinctHosts(hosts []string) []string {
+	var distinct []string
+	seen := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		if !seen[h] {
+			seen[h] = true
+			distinct = append(distinct, h)
+		}
+	}
+	sort.Strings(distinct)
+	return distinct
 }
 
-func getHosts(li *ClusterLoadInfo, hostLoad map[string]int) (int, []string) {
+// leastLoadedOf returns the hosts with the fewest connections among hosts, and their connection count.
+func leastLoadedOf(hostLoad map[string]int, hosts []string) (int, []string) {
 	leastCnt := int(math.MaxInt32)
 	leastLoadedservers := make([]string, 0)
-	for h := range hostLoad {
-		if !isHostAway(li, h) {
-			if hostLoad[h] < leastCnt {
-				leastLoadedservers = nil
-				leastLoadedservers = append(leastLoadedservers, h)
-				leastCnt = hostLoad[h]
-			} else if hostLoad[h] == leastCnt {
-				leastLoadedservers = append(leastLoadedservers, h)
-			}
+	for _, h := range hosts {
+		if hostLoad[h] < leastCnt {
+			leastLoadedservers = nil
+			leastLoadedservers = append(leastLoadedservers, h)
+			leastCnt = hostLoad[h]
+		} else if hostLoad[h] == leastCnt {
+			leastLoadedservers = append(leastLoadedservers, h)
 		}
 	}
 	return leastCnt, leastLoadedservers
 }
 
 func isHostAway(li *ClusterLoadInfo, h string) bool {
+	if isHostDrained(li, h) {
+		return true
+	}
 	for awayHost := range li.unavailableHosts {
 		if h == awayHost || h == li.hostPairs[awayHost] {
 			return true
@@ -621,8 +2788,42 @@ func isHostAway(li *ClusterLoadInfo, h string) bool {
 	return false
 }
 
+// isHostAllowed reports whether h, or its public address, is in config.allowHosts, if set.
+func isHostAllowed(li *ClusterLoadInfo, h string) bool {
+	if li.config.allowHosts == nil {
+		return true
+	}
+	return li.config.allowHosts[h] || li.config.allowHosts[li.hostPairs[h]]
+}
+
+// isHostLagging reports whether h is a read replica whose replication lag exceeds config.maxReplicaLagMs. Servers not
+// reporting their lag are never lagging.
+func isHostLagging(li *ClusterLoadInfo, h string) bool {
+	if li.config.maxReplicaLagMs <= 0 {
+		return false
+	}
+	if _, ok := li.hostLoadRR[h]; !ok {
+		return false
+	}
+	lag, ok := li.replicationLag[h]
+	return ok && lag > li.config.maxReplicaLagMs
+}
+
+// isHostFull reports whether the connection count of h reached config.maxConnsPerHost.
+func isHostFull(li *ClusterLoadInfo, h string) bool {
+	if li.config.maxConnsPerHost <= 0 {
+		return false
+	}
+	if cnt, ok := li.hostLoadPrimary[h]; ok {
+		return cnt >= li.config.maxConnsPerHost
+	}
+	return li.hostLoadRR[h] >= li.config.maxConnsPerHost
+}
+
 func refreshAndGetLeastLoadedHost(li *ClusterLoadInfo, awayHosts map[string]int64) *lbHost {
-	if time.Now().Unix()-li.lastRefresh.Unix() > li.config.refreshInterval {
+	if li.refreshPaused {
+		recoverUnavailableHosts(li)
+	} else if time.Now().Unix()-li.lastRefresh.Unix() > int64(li.config.RefreshInterval/time.Second) {
 		err := refreshLoadInfo(li)
 		if err != nil {
 			return &lbHost{
@@ -633,23 +2834,104 @@ func refreshAndGetLeastLoadedHost(li *ClusterLoadInfo, awayHosts map[string]int6
 	}
 
 	for h := range awayHosts {
-		li.unavailableHosts[h] = awayHosts[h]
+		addUnavailableHost(li, h, awayHosts[h])
+		quarantineHost(li, h)
+		recordDataConnectFailure(li, h)
+		emitLBEvent(li.config, LBEvent{Type: LBEventHostMarkedAway, ClusterName: li.clusterName, Host: h})
 	}
 	return getHostWithLeastConns(li)
 }
 
-// expects the toplogykeys in the format 'cloud1.region1.zone1,cloud1.region1.zone2,...'
-func validateTopologyKeys(s string) ([]string, error) {
-	tkeys := strings.Split(s, ",")
-	for _, tk := range tkeys {
+// privateHost returns the private address of h, which may be the private or the public address of a server. It
+// returns "" if h is not a server of li.
+func privateHost(li *ClusterLoadInfo, h string) string {
+	if _, ok := li.hostPort[h]; ok {
+		return h
+	}
+	for private, public := range li.hostPairs {
+		if public != "" && public == h {
+			return private
+		}
+	}
+	return ""
+}
+
+// recordDataConnectFailure counts a failed connect to h. If the control connection keeps listing h while connects to it
+// keep failing, the control and the data paths disagree on its reachability, which rather points at the network
+// configuration than at the server.
+func recordDataConnectFailure(li *ClusterLoadInfo, h string) {
+	private := privateHost(li, h)
+	if private == "" {
+		return
+	}
+	if li.dataConnectFailures == nil {
+		li.dataConnectFailures = make(map[string]int)
+	}
+	li.dataConnectFailures[private]++
+	if li.dataConnectFailures[private] < ASYMMETRIC_REACHABILITY_FAILURES {
+		return
+	}
+	if _, ok := li.asymmetricHosts[private]; ok {
+		return
+	}
+	if li.asymmetricHosts == nil {
+		li.asymmetricHosts = make(map[string]time.Time)
+	}
+	li.asymmetricHosts[private] = time.Now()
+	lbLogf(li.config, LBLogLevelWarn, "%s is listed by yb_servers() on %s but %d connects to it failed in a row, "+
+		"check the network configuration between the client and the server", h, li.config.controlHost,
+		li.dataConnectFailures[private])
+	emitLBEvent(li.config, LBEvent{Type: LBEventAsymmetricReachability, ClusterName: li.clusterName, Host: private})
+}
+
+// recordDataConnectSuccess clears the failed connects counted against h.
+func recordDataConnectSuccess(li *ClusterLoadInfo, h string) {
+	if private := privateHost(li, h); private != "" {
+		delete(li.dataConnectFailures, private)
+		delete(li.asymmetricHosts, private)
+	}
+}
+
+// parseTopologyKeys expects the toplogykeys in the format 'cloud1.region1.zone1:1,cloud1.region1.zone2:2,...' and
+// returns them grouped by their preference value minus one, the tier they are tried in. Keys without a preference
+// value have the preference 1. Empty keys, e.g. of a trailing comma, are skipped. It returns nil if there is no key.
+func parseTopologyKeys(s string) (map[int][]string, error) {
+	var topologyKeys map[int][]string
+	for _, tk := range strings.Split(s, ",") {
+		if tk == "" {
+			continue
+		}
 		zones1 := strings.Split(tk, ".")
 		zones2 := strings.Split(tk, ":")
 		if len(zones1) != 3 || len(zones2) > 2 {
 			return nil, errors.New("toplogy_keys '" + s +
 				"' not in correct format, should be specified as '<cloud>.<region>.<zone>,...'")
 		}
+		preference := 1
+		if len(zones2) == 2 {
+			num, err := strconv.Atoi(zones2[1])
+			if err != nil || num < 1 || num > MAX_PREFERENCE_VALUE {
+				return nil, fmt.Errorf("Invalid preference value for %s: %s", zones2[0], zones2[1])
+			}
+			preference = num
+		}
+		if topologyKeys == nil {
+			topologyKeys = make(map[int][]string)
+		}
+		topologyKeys[preference-1] = append(topologyKeys[preference-1], zones2[0])
+	}
+	return topologyKeys, nil
+}
+
+// topologyTiers returns the tiers of topologyKeys in the order they are tried, lowest preference value first. The
+// preference values need not be contiguous.
+func topologyTiers(topologyKeys map[int][]string) []int {
+	tiers := make([]int, 0, len(topologyKeys))
+	for tier := range topologyKeys {
+		tiers = append(tiers, tier)
 	}
-	return tkeys, nil
+	sort.Ints(tiers)
+	return tiers
 }
 
 // expects the loadBalance to be one of "true", "false", "only-rr", "only-primary", "prefer-rr", "prefer-primary" and "any"
@@ -669,29 +2951,17 @@ func validateLoadBalance(s string) bool {
 	return false
 }
 
-// For test purpose
-func GetHostLoad() map[string]map[string]int {
-	hl := make(map[string]map[string]int)
-	for cluster := range clustersLoadInfo {
-		hl[cluster] = make(map[string]int)
-		for host, cnt := range clustersLoadInfo[cluster].hostLoadPrimary {
-			hl[cluster][host] = cnt
-		}
-		for host, cnt := range clustersLoadInfo[cluster].hostLoadRR {
-			hl[cluster][host] = cnt
-		}
-	}
-	return hl
-}
-
 // For test purpose
 func GetAZInfo() map[string]map[string][]string {
 	az := make(map[string]map[string][]string)
-	for n, cli := range clustersLoadInfo {
-		az[n] = make(map[string][]string)
-		copyZoneList(az[n], cli.zoneListPrimary)
-		copyZoneList(az[n], cli.zoneListRR)
-	}
+	inspectLoadInfo(func(clusters map[string]*ClusterLoadInfo) error {
+		for n, cli := range clusters {
+			az[n] = make(map[string][]string)
+			copyZoneList(az[n], cli.zoneListPrimary)
+			copyZoneList(az[n], cli.zoneListRR)
+		}
+		return nil
+	})
 	return az
 }
 
@@ -709,13 +2979,24 @@ func copyZoneList(azn map[string][]string, zl map[string][]string) {
 
 // For test purpose
 func EmptyHostLoad() map[string]map[string]int {
-	for cluster := range clustersLoadInfo {
-		for host := range clustersLoadInfo[cluster].hostLoadPrimary {
-			delete(clustersLoadInfo[cluster].hostLoadPrimary, host)
-		}
-		for host := range clustersLoadInfo[cluster].hostLoadRR {
-			delete(clustersLoadInfo[cluster].hostLoadRR, host)
+	inspectLoadInfo(func(clusters map[string]*ClusterLoadInfo) error {
+		for _, cli := range clusters {
+			for host := range cli.hostLoadPrimary {
+				delete(cli.hostLoadPrimary, host)
+			}
+			for host := range cli.hostLoadRR {
+				delete(cli.hostLoadRR, host)
+			}
 		}
-	}
+		return nil
+	})
 	return nil
 }
+
+// redactSecrets masks the passwords and other sensitive parameters of the connection strings in s, which may be a
+// connection string or a message containing one. Every value logged by the load balancer that may contain a
+// connection string must be passed through it.
+func redactSecrets(s string) string {
+	s = urlPassword.ReplaceAllString(s, "$1:xxxxx@")
+	return sensitiveParams.ReplaceAllString(s, "$1=xxxxx")
+}
@@ -0,0 +1,183 @@
+package pgx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time given to the instance metadata services to report the location of the client for topology_keys=auto.
+const CLOUD_METADATA_TIMEOUT = 2 * time.Second
+
+// Base URLs of the instance metadata services queried for topology_keys=auto.
+var cloudMetadataURLs = struct {
+	aws   string
+	gcp   string
+	azure string
+}{
+	aws:   "http://169.254.169.254",
+	gcp:   "http://metadata.google.internal",
+	azure: "http://169.254.169.254",
+}
+
+// cloudLocation is the placement of the client, in the format of the cloud, region and zone of yb_servers().
+type cloudLocation struct {
+	cloud  string
+	region string
+	zone   string
+}
+
+// autoTopology holds the location of the client, which is detected once for all the connects with topology_keys=auto.
+var autoTopology = struct {
+	sync.Mutex
+	detected bool
+	location *cloudLocation
+}{}
+
+// autoTopologyKeys returns the topology keys of topology_keys=auto: the zone of the client first, then its region. It
+// returns nil, so that the servers of all the zones are used, if the client does not run on AWS, GCP or Azure.
+func autoTopologyKeys(ctx context.Context, config *ConnConfig) map[int][]string {
+	autoTopology.Lock()
+	defer autoTopology.Unlock()
+	if !autoTopology.detected {
+		location, err := detectCloudLocation(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil // detected by the next connect
+			}
+			lbLogf(config, LBLogLevelWarn, "Could not detect the cloud location of the client for topology_keys=auto, "+
+				"the servers of all the zones are used: %s", err)
+		} else {
+			lbLogf(config, LBLogLevelInfo, "Detected cloud location %s.%s.%s for topology_keys=auto", location.cloud,
+				location.region, location.zone)
+		}
+		autoTopology.detected = true
+		autoTopology.location = location
+	}
+	location := autoTopology.location
+	if location == nil {
+		return nil
+	}
+	return map[int][]string{
+		0: {location.cloud + "." + location.region + "." + location.zone},
+		1: {location.cloud + "." + location.region + ".*"},
+	}
+}
+
+// detectCloudLocation queries the instance metadata services of AWS, GCP and Azure concurrently and returns the
+// location reported by the first one answering.
+func detectCloudLocation(ctx context.Context) (*cloudLocation, error) {
+	ctx, cancel := context.WithTimeout(ctx, CLOUD_METADATA_TIMEOUT)
+	defer cancel()
+	detectors := []func(context.Context) (*cloudLocation, error){awsLocation, gcpLocation, azureLocation}
+	type result struct {
+		location *cloudLocation
+		err      error
+	}
+	results := make(chan result, len(detectors))
+	for _, detect := range detectors {
+		go func(detect func(context.Context) (*cloudLocation, error)) {
+			location, err := detect(ctx)
+			results <- result{location, err}
+		}(detect)
+	}
+	var errs []string
+	for range detectors {
+		r := <-results
+		if r.err == nil {
+			return r.location, nil
+		}
+		errs = append(errs, r.err.Error())
+	}
+	return nil, errors.New(strings.Join(errs, ", "))
+}
+
+// awsLocation reads the availability zone and the region of an EC2 instance with IMDSv2.
+func awsLocation(ctx context.Context) (*cloudLocation, error) {
+	token, err := metadataGet(ctx, http.MethodPut, cloudMetadataURLs.aws+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return nil, fmt.Errorf("aws: %w", err)
+	}
+	header := map[string]string{"X-aws-ec2-metadata-token": token}
+	zone, err := metadataGet(ctx, http.MethodGet, cloudMetadataURLs.aws+"/latest/meta-data/placement/availability-zone",
+		header)
+	if err != nil {
+		return nil, fmt.Errorf("aws: %w", err)
+	}
+	region, err := metadataGet(ctx, http.MethodGet, cloudMetadataURLs.aws+"/latest/meta-data/placement/region", header)
+	if err != nil {
+		return nil, fmt.Errorf("aws: %w", err)
+	}
+	return &cloudLocation{cloud: "aws", region: region, zone: zone}, nil
+}
+
+// gcpLocation reads the zone of a Compute Engine instance, e.g. projects/1234/zones/us-central1-a, of which the region
+// is the zone without its last dash-separated part.
+func gcpLocation(ctx context.Context) (*cloudLocation, error) {
+	path, err := metadataGet(ctx, http.MethodGet, cloudMetadataURLs.gcp+"/computeMetadata/v1/instance/zone",
+		map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return nil, fmt.Errorf("gcp: %w", err)
+	}
+	zone := path[strings.LastIndex(path, "/")+1:]
+	i := strings.LastIndex(zone, "-")
+	if i <= 0 {
+		return nil, fmt.Errorf("gcp: unexpected zone %q", path)
+	}
+	return &cloudLocation{cloud: "gcp", region: zone[:i], zone: zone}, nil
+}
+
+// azureLocation reads the location and the availability zone of an Azure virtual machine. The zone is reported as
+// <location>-<zone>, e.g. eastus-1, like YugabyteDB names the zones of Azure regions, and it is the region for the
+// virtual machines deployed without availability zone.
+func azureLocation(ctx context.Context) (*cloudLocation, error) {
+	body, err := metadataGet(ctx, http.MethodGet,
+		cloudMetadataURLs.azure+"/metadata/instance/compute?api-version=2021-02-01", map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, fmt.Errorf("azure: %w", err)
+	}
+	var compute struct {
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := json.Unmarshal([]byte(body), &compute); err != nil || compute.Location == "" {
+		return nil, fmt.Errorf("azure: unexpected instance metadata %q", body)
+	}
+	zone := compute.Location
+	if compute.Zone != "" {
+		zone += "-" + compute.Zone
+	}
+	return &cloudLocation{cloud: "azu", region: compute.Location, zone: zone}, nil
+}
+
+// metadataGet makes a request to an instance metadata service and returns the body of the response, which must be
+// successful.
+func metadataGet(ctx context.Context, method string, url string, header map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
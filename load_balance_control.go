@@ -0,0 +1,110 @@
+package pgx
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// checkControlConn pings the control connection of li before the servers are queried on it. If the ping fails, or
+// there is no control connection, the first standby control connection answering a ping replaces it, see
+// config.controlConns. The refresh only dials a new control connection if none of them does.
+func checkControlConn(li *ClusterLoadInfo) {
+	if li.controlConn != nil && !li.controlConn.IsClosed() {
+		err := li.controlConn.Ping(li.ctrlCtx)
+		if err == nil || li.ctx.Err() != nil {
+			return
+		}
+		lbLogf(li.config, LBLogLevelWarn, "Control connection to %s did not answer a ping: %s", li.config.controlHost,
+			redactSecrets(err.Error()))
+		closeControlConn(li.controlConn)
+		markHostAway(li, li.config.controlHost)
+		li.controlConn = nil
+	}
+	for len(li.standbyControlConns) > 0 {
+		standby := li.standbyControlConns[0]
+		li.standbyControlConns = li.standbyControlConns[1:]
+		if standby.IsClosed() || isHostAway(li, standby.config.Host) {
+			closeControlConn(standby)
+			continue
+		}
+		if err := standby.Ping(li.ctrlCtx); err != nil {
+			if li.ctx.Err() != nil {
+				li.standbyControlConns = append(li.standbyControlConns, standby)
+				return
+			}
+			lbLogf(li.config, LBLogLevelWarn, "Standby control connection to %s did not answer a ping", standby.config.Host)
+			closeControlConn(standby)
+			markHostAway(li, standby.config.Host)
+			continue
+		}
+		lbLogf(li.config, LBLogLevelInfo, "Control connection of %s failed over to %s", li.clusterName, standby.config.Host)
+		li.controlConn = standby
+		li.config = li.config.cloneWithHost(standby.config.Host, standby.config.Port)
+		li.config.controlHost = li.config.Host
+		li.controlHostSince = time.Now()
+		li.controlFailovers++
+		emitLBEvent(li.config,
+			LBEvent{Type: LBEventControlConnChanged, ClusterName: li.clusterName, Host: li.config.controlHost})
+		return
+	}
+}
+
+// fillStandbyControlConns opens standby control connections to the servers of li, up to config.controlConns control
+// connections in all, each to a different server. The standbys to servers which are gone or marked away are closed.
+func fillStandbyControlConns(li *ClusterLoadInfo) {
+	used := map[string]bool{li.config.controlHost: true}
+	standbys := li.standbyControlConns[:0]
+	for _, standby := range li.standbyControlConns {
+		_, listed := li.hostPort[standby.config.Host]
+		if standby.IsClosed() || !listed || used[standby.config.Host] || isHostAway(li, standby.config.Host) ||
+			len(standbys)+1 >= li.config.controlConns {
+			closeControlConn(standby)
+			continue
+		}
+		used[standby.config.Host] = true
+		standbys = append(standbys, standby)
+	}
+	li.standbyControlConns = standbys
+
+	hosts := make([]string, 0, len(li.hostPort))
+	for h := range li.hostPort {
+		if !used[h] && !isHostAway(li, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	sort.Strings(hosts)
+	for _, h := range hosts {
+		if len(li.standbyControlConns)+1 >= li.config.controlConns || li.ctx.Err() != nil {
+			return
+		}
+		standby, err := connect(li.ctrlCtx, li.config.cloneWithHost(h, li.hostPort[h]))
+		if err != nil {
+			lbLogf(li.config, LBLogLevelWarn, "Could not create standby control connection to %s: %s", h,
+				redactSecrets(err.Error()))
+			continue
+		}
+		li.standbyControlConns = append(li.standbyControlConns, standby)
+	}
+}
+
+// closeControlConn closes conn, a control connection, if it is open.
+func closeControlConn(conn *Conn) {
+	if conn == nil || conn.IsClosed() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), conn.config.ControlConnTimeout)
+	defer cancel()
+	conn.PgConn().Close(ctx)
+}
+
+// standbyControlHosts returns the servers the open standby control connections of li are made to.
+func standbyControlHosts(li *ClusterLoadInfo) []string {
+	var hosts []string
+	for _, standby := range li.standbyControlConns {
+		if !standby.IsClosed() {
+			hosts = append(hosts, standby.config.Host)
+		}
+	}
+	return hosts
+}
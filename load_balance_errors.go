@@ -0,0 +1,136 @@
+package pgx
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// SkipReason is why a server of the cluster could not be used by a load balanced connect.
+type SkipReason string
+
+const (
+	// SkipReasonAway is a server marked unavailable after failed connects, or drained by MarkHostUnavailable.
+	SkipReasonAway SkipReason = "away"
+	// SkipReasonNotAllowed is a server missing from load_balance_allow_hosts.
+	SkipReasonNotAllowed SkipReason = "not_allowed"
+	// SkipReasonNodeType is a primary or a read replica excluded by load_balance.
+	SkipReasonNodeType SkipReason = "node_type"
+	// SkipReasonLagging is a read replica lagging more than load_balance_max_replica_lag_ms.
+	SkipReasonLagging SkipReason = "lagging"
+	// SkipReasonCapped is a server having load_balance_max_conns_per_host connections.
+	SkipReasonCapped SkipReason = "capped"
+	// SkipReasonNoPublicIP is a server without public address while the public addresses are used.
+	SkipReasonNoPublicIP SkipReason = "no_public_ip"
+)
+
+// SkippedServer is a server of the cluster which could not be used by a load balanced connect.
+type SkippedServer struct {
+	Host   string
+	Reason SkipReason
+}
+
+// NoServersAvailableError is returned by a load balanced connect when load information is available but none of the
+// servers of the cluster can be used. It matches ErrNoServersAvailable with errors.Is, and tells with errors.As why
+// every server was skipped.
+type NoServersAvailableError struct {
+	ClusterName string
+	// TopologyKeys are the topology_keys the servers were selected with, by preference value minus one, nil if not set.
+	TopologyKeys map[int][]string
+	// Skipped are the servers of the cluster in lexical order, with the reason each could not be used.
+	Skipped []SkippedServer
+	// detail summarizes the reasons, e.g. when every server has load_balance_max_conns_per_host connections
+	detail string
+}
+
+func (e *NoServersAvailableError) Error() string {
+	msg := NO_SERVERS_MSG
+	if e.detail != "" {
+		msg += ": " + e.detail
+	}
+	if len(e.Skipped) != 0 {
+		skipped := make([]string, len(e.Skipped))
+		for i, s := range e.Skipped {
+			skipped[i] = s.Host + " " + string(s.Reason)
+		}
+		msg += " (" + strings.Join(skipped, ", ") + ")"
+	}
+	return msg
+}
+
+// Is tells if target is ErrNoServersAvailable.
+func (e *NoServersAvailableError) Is(target error) bool {
+	return target == ErrNoServersAvailable
+}
+
+// noServersAvailable returns the NoServersAvailableError of a selection from li which found no server to use. With
+// usePublicIP, the servers without public address are skipped.
+func noServersAvailable(li *ClusterLoadInfo, usePublicIP bool) *NoServersAvailableError {
+	e := &NoServersAvailableError{ClusterName: li.clusterName}
+	if li.config.topologyKeys != nil {
+		e.TopologyKeys = make(map[int][]string, len(li.config.topologyKeys))
+		for i, tks := range li.config.topologyKeys {
+			e.TopologyKeys[i] = append([]string(nil), tks...)
+		}
+	}
+	hosts := make([]string, 0, len(li.hostPort))
+	for h := range li.hostPort {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	reasons := make(map[SkipReason]int)
+	for _, h := range hosts {
+		if reason := skipReason(li, h, usePublicIP); reason != "" {
+			e.Skipped = append(e.Skipped, SkippedServer{Host: h, Reason: reason})
+			reasons[reason]++
+		}
+	}
+	if reasons[SkipReasonCapped] != 0 {
+		e.detail = "every available server has load_balance_max_conns_per_host connections"
+	} else if li.config.allowHosts != nil {
+		e.detail = "none of the hosts of load_balance_allow_hosts is available"
+	}
+	return e
+}
+
+// skipReason returns why h could not be used, "" if it could.
+func skipReason(li *ClusterLoadInfo, h string, usePublicIP bool) SkipReason {
+	_, primary := li.hostLoadPrimary[h]
+	_, rr := li.hostLoadRR[h]
+	switch {
+	case isHostAway(li, h) || (!primary && !rr): // the servers marked away are no longer part of either load map
+		return SkipReasonAway
+	case !isHostAllowed(li, h):
+		return SkipReasonNotAllowed
+	case (li.config.loadBalance == "only-rr" && primary) || (li.config.loadBalance == "only-primary" && rr):
+		return SkipReasonNodeType
+	case isHostLagging(li, h):
+		return SkipReasonLagging
+	case isHostFull(li, h):
+		return SkipReasonCapped
+	case usePublicIP && li.hostPairs[h] == "":
+		return SkipReasonNoPublicIP
+	}
+	return ""
+}
+
+// MultiClusterError is returned by ConnectMultiCluster when neither the primary nor the standby cluster could be
+// connected to. It matches with errors.Is and errors.As what either of its errors matches.
+type MultiClusterError struct {
+	Primary error
+	Standby error
+}
+
+func (e *MultiClusterError) Error() string {
+	return "primary cluster: " + e.Primary.Error() + ", standby cluster: " + e.Standby.Error()
+}
+
+// Is tells if the error of the primary or of the standby cluster matches target.
+func (e *MultiClusterError) Is(target error) bool {
+	return errors.Is(e.Primary, target) || errors.Is(e.Standby, target)
+}
+
+// As finds the first error of the primary, then of the standby cluster that matches target.
+func (e *MultiClusterError) As(target any) bool {
+	return errors.As(e.Primary, target) || errors.As(e.Standby, target)
+}
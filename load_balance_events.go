@@ -0,0 +1,208 @@
+package pgx
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LB_EVENT_BUFFER_SIZE is the number of events buffered for a subscriber of SubscribeLoadBalancerEvents. Further
+// events are dropped until the subscriber catches up.
+const LB_EVENT_BUFFER_SIZE = 256
+
+// LBEventType is the type of an LBEvent.
+type LBEventType int
+
+const (
+	// LBEventClusterCreated is emitted when load information is created for a cluster, before its first refresh.
+	LBEventClusterCreated LBEventType = iota
+	// LBEventRefreshed is emitted after the servers of a cluster were refreshed. Added and Removed hold the hosts which
+	// appeared and disappeared since the previous refresh.
+	LBEventRefreshed
+	// LBEventHostMarkedAway is emitted when Host is marked as unavailable.
+	LBEventHostMarkedAway
+	// LBEventHostRecovered is emitted when Host is no longer marked as unavailable.
+	LBEventHostRecovered
+	// LBEventControlConnChanged is emitted when a control connection is created to Host.
+	LBEventControlConnChanged
+	// LBEventFlagsChanged is emitted when the address type of the cluster changes to Flags.
+	LBEventFlagsChanged
+	// LBEventClusterEvicted is emitted when the load information of a cluster is dropped, e.g. because its first
+	// refresh failed.
+	LBEventClusterEvicted
+	// LBEventAsymmetricReachability is emitted when connects to Host keep failing while the control connection still
+	// lists it as a server of the cluster.
+	LBEventAsymmetricReachability
+	// LBEventFallbackLevelChanged is emitted when a server is selected from another level of the fallback ladder than
+	// the previous one, FallbackLevel, e.g. because the servers matching topology_keys went down or came back.
+	LBEventFallbackLevelChanged
+	// LBEventCircuitOpened is emitted when load_balance_circuit_failures selections in a row failed, and connects to
+	// the cluster are made without load balancing until load_balance_circuit_cooldown_secs elapsed.
+	LBEventCircuitOpened
+	// LBEventCircuitClosed is emitted when a selection succeeded after the circuit of the cluster was opened.
+	LBEventCircuitClosed
+)
+
+func (t LBEventType) String() string {
+	switch t {
+	case LBEventClusterCreated:
+		return "cluster_created"
+	case LBEventRefreshed:
+		return "refreshed"
+	case LBEventHostMarkedAway:
+		return "host_marked_away"
+	case LBEventHostRecovered:
+		return "host_recovered"
+	case LBEventControlConnChanged:
+		return "control_conn_changed"
+	case LBEventFlagsChanged:
+		return "flags_changed"
+	case LBEventClusterEvicted:
+		return "cluster_evicted"
+	case LBEventAsymmetricReachability:
+		return "asymmetric_reachability"
+	case LBEventFallbackLevelChanged:
+		return "fallback_level_changed"
+	case LBEventCircuitOpened:
+		return "circuit_opened"
+	case LBEventCircuitClosed:
+		return "circuit_closed"
+	default:
+		return "unknown"
+	}
+}
+
+// LBEvent is an event in the lifecycle of the load information of a cluster.
+type LBEvent struct {
+	Type        LBEventType
+	Time        time.Time
+	ClusterName string
+	Host        string
+	Added       []string
+	Removed     []string
+	Flags       byte
+	// FallbackLevel is the level of the fallback ladder of an LBEventFallbackLevelChanged.
+	FallbackLevel FallbackLevel
+	// Dropped is the number of events dropped for the subscriber since the previous event it received.
+	Dropped uint64
+}
+
+type lbEventSubscriber struct {
+	ch      chan LBEvent
+	dropped uint64
+}
+
+// lbEventSubscribers holds the subscribers to the events of the clusters of a ClusterManager.
+type lbEventSubscribers struct {
+	sync.Mutex
+	subs []*lbEventSubscriber
+}
+
+// SubscribeLoadBalancerEvents returns a channel receiving the events of the load information of all clusters of the
+// default ClusterManager, see ClusterManager.SubscribeLoadBalancerEvents.
+func SubscribeLoadBalancerEvents() <-chan LBEvent {
+	return defaultClusterManager.SubscribeLoadBalancerEvents()
+}
+
+// UnsubscribeLoadBalancerEvents stops sending events to ch, a channel returned by SubscribeLoadBalancerEvents, and
+// closes it.
+func UnsubscribeLoadBalancerEvents(ch <-chan LBEvent) {
+	defaultClusterManager.UnsubscribeLoadBalancerEvents(ch)
+}
+
+// SubscribeLoadBalancerEvents returns a channel receiving the events of the load information of all clusters of m, in
+// the order they happen. Events are dropped rather than delaying the load balancer if the channel is not drained fast
+// enough, see LBEvent.Dropped.
+func (m *ClusterManager) SubscribeLoadBalancerEvents() <-chan LBEvent {
+	sub := &lbEventSubscriber{ch: make(chan LBEvent, LB_EVENT_BUFFER_SIZE)}
+	m.events.Lock()
+	defer m.events.Unlock()
+	m.events.subs = append(m.events.subs, sub)
+	return sub.ch
+}
+
+// UnsubscribeLoadBalancerEvents stops sending events to ch, a channel returned by m.SubscribeLoadBalancerEvents, and
+// closes it.
+func (m *ClusterManager) UnsubscribeLoadBalancerEvents(ch <-chan LBEvent) {
+	m.events.Lock()
+	defer m.events.Unlock()
+	for i, sub := range m.events.subs {
+		if sub.ch == ch {
+			m.events.subs = append(m.events.subs[:i], m.events.subs[i+1:]...)
+			close(sub.ch)
+			return
+		}
+	}
+}
+
+// emitLBEvent sends e to the subscribers of the ClusterManager of config.
+func emitLBEvent(config *ConnConfig, e LBEvent) {
+	events := &config.clusterManager().events
+	events.Lock()
+	defer events.Unlock()
+	if len(events.subs) == 0 {
+		return
+	}
+	e.Time = time.Now()
+	for _, sub := range events.subs {
+		e.Dropped = sub.dropped
+		select {
+		case sub.ch <- e:
+			sub.dropped = 0
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// hostsDelta returns the hosts of after missing in before and the hosts of before missing in after.
+func hostsDelta(before map[string]uint16, after map[string]uint16) (added []string, removed []string) {
+	for h := range after {
+		if _, ok := before[h]; !ok {
+			added = append(added, h)
+		}
+	}
+	for h := range before {
+		if _, ok := after[h]; !ok {
+			removed = append(removed, h)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// notifyTopologyChange calls config.OnTopologyChange with previous, the servers of li before a refresh, and the ones
+// it found if servers were added or removed, or changed their node type. The call waits for the previous one to
+// return.
+func notifyTopologyChange(li *ClusterLoadInfo, previous []TopologyNode) {
+	current := clusterTopology(li)
+	if sameTopology(previous, current) {
+		return
+	}
+	onChange := li.config.OnTopologyChange
+	before := Topology{ClusterName: li.clusterName, Nodes: previous}
+	after := Topology{ClusterName: li.clusterName, Nodes: current}
+	notified, done := li.topologyNotified, make(chan struct{})
+	li.topologyNotified = done
+	go func() {
+		defer close(done)
+		if notified != nil {
+			<-notified
+		}
+		onChange(before, after)
+	}()
+}
+
+// sameTopology tells if a and b, sorted by host, have the same servers with the same node types.
+func sameTopology(a []TopologyNode, b []TopologyNode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Host != b[i].Host || a[i].NodeType != b[i].NodeType {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package pgx
+
+import (
+	"strings"
+)
+
+// FallbackLevel is the level of the fallback ladder a server was selected from when no server matching topology_keys
+// was available. Without load_balance_fallback_ladder, the servers of the whole cluster are used right away.
+type FallbackLevel int
+
+const (
+	// FallbackLevelNone is the level of the servers selected by topology_keys, or of all the servers if they are not
+	// set.
+	FallbackLevelNone FallbackLevel = iota
+	// FallbackLevelRegion is the level of the servers in the other zones of the regions of topology_keys.
+	FallbackLevelRegion
+	// FallbackLevelCloud is the level of the servers in the other regions of the clouds of topology_keys.
+	FallbackLevelCloud
+	// FallbackLevelAll is the level of the servers of the whole cluster.
+	FallbackLevelAll
+)
+
+func (l FallbackLevel) String() string {
+	switch l {
+	case FallbackLevelNone:
+		return "none"
+	case FallbackLevelRegion:
+		return "region"
+	case FallbackLevelCloud:
+		return "cloud"
+	case FallbackLevelAll:
+		return "all"
+	default:
+		return "unknown"
+	}
+}
+
+// fallbackLadderHosts returns the servers of zonelist at level of the fallback ladder of topologyKeys: the ones in the
+// regions of the keys for FallbackLevelRegion, the ones in their clouds for FallbackLevelCloud.
+func fallbackLadderHosts(zonelist map[string][]string, topologyKeys map[int][]string, level FallbackLevel) []string {
+	prefixes := make(map[string]bool)
+	for _, tks := range topologyKeys {
+		for _, tk := range tks {
+			parts := strings.Split(tk, ".")
+			if level == FallbackLevelRegion {
+				prefixes[parts[0]+"."+parts[1]+"."] = true
+			} else {
+				prefixes[parts[0]+"."] = true
+			}
+		}
+	}
+	var hosts []string
+	for tk, zoneHosts := range zonelist {
+		// The cloud.region entries of the keys of type cloud.region.* repeat the hosts of their zones.
+		if strings.Count(tk, ".") < 2 {
+			continue
+		}
+		for prefix := range prefixes {
+			if strings.HasPrefix(tk, prefix) {
+				hosts = append(hosts, zoneHosts...)
+				break
+			}
+		}
+	}
+	return hosts
+}
+
+// recordFallbackLevel keeps the level of the fallback ladder the latest server of the cluster was selected from, and
+// reports the changes of level.
+func recordFallbackLevel(li *ClusterLoadInfo, level FallbackLevel) {
+	if level == li.fallbackLevel {
+		return
+	}
+	if level == FallbackLevelNone {
+		lbLogf(li.config, LBLogLevelInfo, "Servers matching topology_keys are available again")
+	} else {
+		lbLogf(li.config, LBLogLevelWarn, "No server matching topology_keys is available, falling back to level %s",
+			level)
+	}
+	li.fallbackLevel = level
+	emitLBEvent(li.config, LBEvent{Type: LBEventFallbackLevelChanged, ClusterName: li.clusterName, FallbackLevel: level})
+}
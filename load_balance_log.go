@@ -0,0 +1,88 @@
+package pgx
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// LBLogLevel is the level of a message logged by the load balancer. The values match the ones of tracelog.LogLevel.
+type LBLogLevel int
+
+const (
+	LBLogLevelDebug = LBLogLevel(5)
+	LBLogLevelInfo  = LBLogLevel(4)
+	LBLogLevelWarn  = LBLogLevel(3)
+	LBLogLevelError = LBLogLevel(2)
+)
+
+func (l LBLogLevel) String() string {
+	switch l {
+	case LBLogLevelDebug:
+		return "debug"
+	case LBLogLevelInfo:
+		return "info"
+	case LBLogLevelWarn:
+		return "warn"
+	case LBLogLevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("invalid level %d", l)
+	}
+}
+
+// LBLogger receives the diagnostics of the load balancer, e.g. the servers marked unavailable or the failed refreshes
+// of a cluster. The secrets of connection strings are redacted from msg.
+type LBLogger interface {
+	Log(level LBLogLevel, msg string)
+}
+
+// LBLoggerFunc is a function satisfying LBLogger.
+type LBLoggerFunc func(level LBLogLevel, msg string)
+
+// Log calls f.
+func (f LBLoggerFunc) Log(level LBLogLevel, msg string) {
+	f(level, msg)
+}
+
+// DiscardLBLogger is an LBLogger dropping every message, to silence the load balancer.
+var DiscardLBLogger LBLogger = LBLoggerFunc(func(LBLogLevel, string) {})
+
+// stdLBLogger writes the messages to the standard logger of the log package.
+type stdLBLogger struct{}
+
+func (stdLBLogger) Log(level LBLogLevel, msg string) {
+	log.Printf("pgx load balancer %s: %s", level, msg)
+}
+
+var defaultLBLogger = struct {
+	sync.RWMutex
+	l LBLogger
+}{l: stdLBLogger{}}
+
+// SetDefaultLBLogger sets the LBLogger of the connections whose ConnConfig.LBLogger is nil, and of the messages not
+// related to a connection. By default they are written to the standard logger of the log package. A nil logger
+// restores the default.
+func SetDefaultLBLogger(logger LBLogger) {
+	if logger == nil {
+		logger = stdLBLogger{}
+	}
+	defaultLBLogger.Lock()
+	defer defaultLBLogger.Unlock()
+	defaultLBLogger.l = logger
+}
+
+// lbLogf logs a message of the load balancer formatted like fmt.Sprintf to the LBLogger of config, or to the default
+// one if config, which may be nil, has none.
+func lbLogf(config *ConnConfig, level LBLogLevel, format string, args ...any) {
+	var logger LBLogger
+	if config != nil {
+		logger = config.LBLogger
+	}
+	if logger == nil {
+		defaultLBLogger.RLock()
+		logger = defaultLBLogger.l
+		defaultLBLogger.RUnlock()
+	}
+	logger.Log(level, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,155 @@
+package pgx
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/yugabyte/pgx/v5/pgconn"
+)
+
+// hostProbe is the probing schedule of a host marked unavailable.
+type hostProbe struct {
+	// time of the next probe
+	next time.Time
+	// time waited after the next probe if it fails, doubled by every failed probe up to
+	// MAX_FAILED_HOST_RECONNECT_DELAY_SECS
+	backoff time.Duration
+}
+
+// probeTarget is a host due for a probe.
+type probeTarget struct {
+	host      string
+	addr      string
+	responded bool
+}
+
+// startProbing starts probing the hosts of the cluster clusterName marked unavailable, unless they are probed already.
+// The hosts are probed every config.probeInterval, with a backoff for every host failing its probe, and made available
+// again as soon as they accept a TCP connection. It stops when m is shut down or probing is no longer enabled.
+func (m *ClusterManager) startProbing(clusterName string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	li, ok := m.clusters[clusterName]
+	if m.stopped || !ok || !atomic.CompareAndSwapInt32(&li.probing, 0, 1) {
+		return
+	}
+	m.probers.Add(1)
+	go m.probeUnavailableHosts(li)
+}
+
+func (m *ClusterManager) probeUnavailableHosts(li *ClusterLoadInfo) {
+	defer m.probers.Done()
+	defer atomic.StoreInt32(&li.probing, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		li.mu.Lock()
+		interval := li.config.probeInterval
+		targets := dueProbes(li, time.Now())
+		dial, timeout, maxTimeout := li.config.DialFunc, li.config.ConnectTimeout, li.config.ControlConnTimeout
+		li.mu.Unlock()
+		if interval <= 0 {
+			return
+		}
+
+		for i := range targets {
+			targets[i].responded = probeHost(ctx, dial, timeout, maxTimeout, targets[i].addr)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		li.mu.Lock()
+		applyProbes(li, targets, time.Now())
+		li.mu.Unlock()
+
+		select {
+		case <-m.shutdown:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// dueProbes returns the hosts marked unavailable whose next probe is due at now. Hosts are first probed
+// config.probeInterval after they were marked.
+func dueProbes(li *ClusterLoadInfo, now time.Time) []probeTarget {
+	if li.probes == nil {
+		li.probes = make(map[string]*hostProbe)
+	}
+	for h := range li.probes {
+		if _, ok := li.unavailableHosts[h]; !ok {
+			delete(li.probes, h)
+		}
+	}
+	var targets []probeTarget
+	for h, t := range li.unavailableHosts {
+		p, ok := li.probes[h]
+		if !ok {
+			p = &hostProbe{next: time.Unix(t, 0).Add(li.config.probeInterval), backoff: li.config.probeInterval}
+			li.probes[h] = p
+		}
+		if now.Before(p.next) {
+			continue
+		}
+		port := li.hostPort[privateHost(li, h)]
+		if port == 0 {
+			port = li.config.Port
+		}
+		targets = append(targets, probeTarget{host: h, addr: net.JoinHostPort(h, strconv.Itoa(int(port)))})
+	}
+	return targets
+}
+
+// applyProbes makes the hosts of targets which responded available again and schedules the next probe of the others.
+func applyProbes(li *ClusterLoadInfo, targets []probeTarget, now time.Time) {
+	for _, target := range targets {
+		p, ok := li.probes[target.host]
+		if _, marked := li.unavailableHosts[target.host]; !ok || !marked {
+			continue // made available meanwhile, e.g. by ResumeRefresh
+		}
+		if !target.responded {
+			p.backoff *= 2
+			if max := MAX_FAILED_HOST_RECONNECT_DELAY_SECS * time.Second; p.backoff > max {
+				p.backoff = max
+			}
+			p.next = now.Add(p.backoff)
+			continue
+		}
+		lbLogf(li.config, LBLogLevelInfo, "%s responded to a probe, removing it from unavailableHosts Map", target.host)
+		restoreHost(li, target.host)
+		delete(li.probes, target.host)
+		_, primary := li.hostLoadPrimary[target.host]
+		_, rr := li.hostLoadRR[target.host]
+		if !primary && !rr {
+			// It was dropped from the servers when it was marked, so they are refreshed by the next connect.
+			li.lastRefresh = time.Time{}
+		}
+	}
+}
+
+// probeHost reports whether a TCP connection to addr can be established with dial within the connect timeout, at
+// most maxTimeout, the ControlConnTimeout of the cluster.
+func probeHost(ctx context.Context, dial pgconn.DialFunc, timeout, maxTimeout time.Duration, addr string) bool {
+	if timeout <= 0 || timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,766 @@
+package pgx
+
+import (
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Maximum number of connect latencies kept per host. Once it is reached, new latencies replace kept ones at random
+// so that the kept ones remain a uniform sample of all latencies observed.
+const CONNECT_LATENCY_RESERVOIR_SIZE = 1024
+
+// LatencyStats summarizes the latencies of the successful connects to a host.
+type LatencyStats struct {
+	Count int64
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+type latencyReservoir struct {
+	samples []time.Duration
+	count   int64
+}
+
+func (r *latencyReservoir) add(d time.Duration) {
+	r.count++
+	if len(r.samples) < CONNECT_LATENCY_RESERVOIR_SIZE {
+		r.samples = append(r.samples, d)
+		return
+	}
+	if i := mathrand.Int63n(r.count); i < CONNECT_LATENCY_RESERVOIR_SIZE {
+		r.samples[i] = d
+	}
+}
+
+func (r *latencyReservoir) stats() LatencyStats {
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return LatencyStats{
+		Count: r.count,
+		P50:   percentile(sorted, 50),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func recordConnectLatency(m *ClusterManager, clusterName string, host string, d time.Duration) {
+	m.withCluster(clusterName, func(li *ClusterLoadInfo) error {
+		if li.connectLatencies == nil {
+			li.connectLatencies = make(map[string]*latencyReservoir)
+		}
+		r, ok := li.connectLatencies[host]
+		if !ok {
+			r = &latencyReservoir{}
+			li.connectLatencies[host] = r
+		}
+		r.add(d)
+		if li.latencyAverages == nil {
+			li.latencyAverages = make(map[string]time.Duration)
+		}
+		if avg, ok := li.latencyAverages[host]; ok {
+			li.latencyAverages[host] = avg + time.Duration(LATENCY_EWMA_WEIGHT*float64(d-avg))
+		} else {
+			li.latencyAverages[host] = d
+		}
+		return nil
+	})
+}
+
+// ConnectLatencies returns the latency statistics of the successful load balanced connects to each host of the
+// cluster connString belongs to. It returns ErrNoLoadInfo if no load balanced connection has been made to that cluster
+// with the default ClusterManager yet.
+func ConnectLatencies(connString string) (map[string]LatencyStats, error) {
+	return defaultClusterManager.ConnectLatencies(connString)
+}
+
+// ConnectLatencies returns the latency statistics of the connects made by m to each host of the cluster connString
+// belongs to, see ConnectLatencies.
+func (m *ClusterManager) ConnectLatencies(connString string) (map[string]LatencyStats, error) {
+	latencies := make(map[string]LatencyStats)
+	err := m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		for h, r := range li.connectLatencies {
+			latencies[h] = r.stats()
+		}
+		return nil
+	})
+	return latencies, err
+}
+
+// LoadBalancerState is a snapshot of the load balancing information of every cluster.
+type LoadBalancerState struct {
+	Clusters []ClusterState `json:"clusters"`
+	// Degraded is true while load balancing is disabled after a panic, see ClusterManager.Degraded.
+	Degraded bool `json:"degraded"`
+}
+
+// ClusterState is a snapshot of the load balancing information of a cluster.
+type ClusterState struct {
+	Name        string    `json:"name"`
+	ControlHost string    `json:"control_host"`
+	LastRefresh time.Time `json:"last_refresh"`
+	Generation  uint64    `json:"generation"`
+	// RefreshPaused is true between PauseRefresh and ResumeRefresh.
+	RefreshPaused bool `json:"refresh_paused"`
+	// SkippedRows is the number of rows of yb_servers() skipped with load_balance_skip_bad_rows.
+	SkippedRows uint64 `json:"skipped_rows"`
+	// ControlConnOpen is true while the control connection to ControlHost is open. StandbyControlHosts are the servers
+	// the standby control connections of load_balance_control_conns are open to, and ControlConnFailovers the number of
+	// times one of them replaced a failed control connection.
+	ControlConnOpen      bool     `json:"control_conn_open"`
+	StandbyControlHosts  []string `json:"standby_control_hosts"`
+	ControlConnFailovers uint64   `json:"control_conn_failovers"`
+	// AddressType is the address of the servers connections are made to: "private", "public", "private_then_public"
+	// or "public_after_private_failed".
+	AddressType string      `json:"address_type"`
+	Hosts       []HostState `json:"hosts"`
+	// UnavailableHosts maps the hosts marked as unavailable to the time they were marked.
+	UnavailableHosts map[string]time.Time `json:"unavailable_hosts"`
+	// AsymmetricHosts maps the hosts connects keep failing to while the control connection lists them to the time it
+	// was detected, see ASYMMETRIC_REACHABILITY_FAILURES.
+	AsymmetricHosts map[string]time.Time `json:"asymmetric_hosts"`
+	// FallbackLevel is the level of the fallback ladder the latest server was selected from, see FallbackLevel.
+	FallbackLevel string             `json:"fallback_level"`
+	Config        ClusterConfigState `json:"config"`
+}
+
+// HostState is a snapshot of the load balancing information of a server.
+type HostState struct {
+	Host        string `json:"host"`
+	Port        uint16 `json:"port"`
+	PublicIP    string `json:"public_ip"`
+	NodeType    string `json:"node_type"`
+	Placement   string `json:"placement"`
+	Connections int    `json:"connections"`
+	// ControlConnection is true for the host the control connection of the cluster is made to.
+	ControlConnection bool `json:"control_connection"`
+}
+
+// ClusterConfigState is the load balancing configuration in use for a cluster, i.e. the one of its latest connect.
+type ClusterConfigState struct {
+	LoadBalance                  string           `json:"load_balance"`
+	TopologyKeys                 map[int][]string `json:"topology_keys"`
+	RefreshIntervalSecs          int64            `json:"refresh_interval_secs"`
+	FallbackToTopologyKeysOnly   bool             `json:"fallback_to_topology_keys_only"`
+	FallbackLadder               bool             `json:"fallback_ladder"`
+	FailedHostReconnectDelaySecs int64            `json:"failed_host_reconnect_delay_secs"`
+}
+
+func addressType(flags byte) string {
+	switch flags {
+	case USE_HOSTS:
+		return "private"
+	case USE_PUBLIC_IP:
+		return "public"
+	case TRY_HOSTS_PUBLIC_IP:
+		return "private_then_public"
+	case HOSTS_EXHAUSTED:
+		return "public_after_private_failed"
+	}
+	return ""
+}
+
+// hostPlacements returns the "cloud.region.zone" of every host of li.
+func hostPlacements(li *ClusterLoadInfo) map[string]string {
+	placements := make(map[string]string)
+	for _, zoneList := range []map[string][]string{li.zoneListPrimary, li.zoneListRR} {
+		for tk, hosts := range zoneList {
+			if strings.Count(tk, ".") != 2 {
+				continue
+			}
+			for _, h := range hosts {
+				placements[h] = tk
+			}
+		}
+	}
+	return placements
+}
+
+// hostPlacement returns the "cloud.region.zone" of h, a host of li.
+func hostPlacement(li *ClusterLoadInfo, h string) string {
+	for _, zoneList := range []map[string][]string{li.zoneListPrimary, li.zoneListRR} {
+		for tk, hosts := range zoneList {
+			if strings.Count(tk, ".") != 2 {
+				continue
+			}
+			for _, host := range hosts {
+				if host == h {
+					return tk
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func clusterState(li *ClusterLoadInfo) ClusterState {
+	state := ClusterState{
+		Name:                 li.clusterName,
+		ControlHost:          li.config.controlHost,
+		LastRefresh:          li.lastRefresh,
+		Generation:           li.generation,
+		RefreshPaused:        li.refreshPaused,
+		SkippedRows:          li.skippedRows,
+		ControlConnOpen:      li.controlConn != nil && !li.controlConn.IsClosed(),
+		StandbyControlHosts:  standbyControlHosts(li),
+		ControlConnFailovers: li.controlFailovers,
+		AddressType:          addressType(li.flags),
+		UnavailableHosts:     make(map[string]time.Time, len(li.unavailableHosts)),
+		AsymmetricHosts:      make(map[string]time.Time, len(li.asymmetricHosts)),
+		FallbackLevel:        li.fallbackLevel.String(),
+		Config: ClusterConfigState{
+			LoadBalance:                  li.config.loadBalance,
+			TopologyKeys:                 make(map[int][]string, len(li.config.topologyKeys)),
+			RefreshIntervalSecs:          int64(li.config.RefreshInterval / time.Second),
+			FallbackToTopologyKeysOnly:   li.config.fallbackToTopologyKeysOnly,
+			FallbackLadder:               li.config.fallbackLadder,
+			FailedHostReconnectDelaySecs: li.config.failedHostReconnectDelaySecs,
+		},
+	}
+	for i, tks := range li.config.topologyKeys {
+		state.Config.TopologyKeys[i] = append([]string(nil), tks...)
+	}
+	for h, t := range li.unavailableHosts {
+		state.UnavailableHosts[h] = time.Unix(t, 0)
+	}
+	for h, t := range li.asymmetricHosts {
+		state.AsymmetricHosts[h] = t
+	}
+	placements := hostPlacements(li)
+	for h, port := range li.hostPort {
+		host := HostState{Host: h, Port: port, PublicIP: li.hostPairs[h], Placement: placements[h]}
+		host.ControlConnection = h == li.config.controlHost || (host.PublicIP != "" && host.PublicIP == li.config.controlHost)
+		// Hosts marked away are no longer part of either load map.
+		if cnt, ok := li.hostLoadPrimary[h]; ok {
+			host.NodeType = "primary"
+			host.Connections = cnt
+		} else if cnt, ok := li.hostLoadRR[h]; ok {
+			host.NodeType = "read_replica"
+			host.Connections = cnt
+		}
+		state.Hosts = append(state.Hosts, host)
+	}
+	sort.Slice(state.Hosts, func(i, j int) bool { return state.Hosts[i].Host < state.Hosts[j].Host })
+	return state
+}
+
+// DumpLoadBalancerState returns a snapshot of the load balancing information of every cluster load balanced
+// connections were made to with the default ClusterManager.
+func DumpLoadBalancerState() LoadBalancerState {
+	return defaultClusterManager.State()
+}
+
+// State returns a snapshot of the load balancing information of every cluster load balanced connections were made to
+// with m.
+func (m *ClusterManager) State() LoadBalancerState {
+	var state LoadBalancerState
+	m.inspectLoadInfo(func(clis map[string]*ClusterLoadInfo) error {
+		for _, li := range clis {
+			state.Clusters = append(state.Clusters, clusterState(li))
+		}
+		return nil
+	})
+	sort.Slice(state.Clusters, func(i, j int) bool { return state.Clusters[i].Name < state.Clusters[j].Name })
+	state.Degraded = m.Degraded()
+	return state
+}
+
+// ClusterStatus is a summary of the load balancing information of a cluster.
+type ClusterStatus struct {
+	Name             string `json:"name"`
+	PrimaryHosts     int    `json:"primary_hosts"`
+	ReadReplicaHosts int    `json:"read_replica_hosts"`
+	UnavailableHosts int    `json:"unavailable_hosts"`
+	// LastSuccessfulRefresh is the time the servers were last read from the cluster, see
+	// TimeSinceLastSuccessfulRefresh.
+	LastSuccessfulRefresh time.Time `json:"last_successful_refresh"`
+	ControlHost           string    `json:"control_host"`
+	// AddressType is the address of the servers connections are made to, see ClusterState.AddressType.
+	AddressType string `json:"address_type"`
+}
+
+// AllClustersStatus returns a summary of the load balancing information of every cluster load balanced connections
+// were made to with the default ClusterManager, ordered by name. Use DumpLoadBalancerState for the details.
+func AllClustersStatus() []ClusterStatus {
+	return defaultClusterManager.AllClustersStatus()
+}
+
+// AllClustersStatus returns a summary of every cluster load balanced connections were made to with m, see
+// AllClustersStatus.
+func (m *ClusterManager) AllClustersStatus() []ClusterStatus {
+	var statuses []ClusterStatus
+	m.inspectLoadInfo(func(clis map[string]*ClusterLoadInfo) error {
+		for _, li := range clis {
+			statuses = append(statuses, ClusterStatus{
+				Name:                  li.clusterName,
+				PrimaryHosts:          len(li.hostLoadPrimary),
+				ReadReplicaHosts:      len(li.hostLoadRR),
+				UnavailableHosts:      len(li.unavailableHosts),
+				LastSuccessfulRefresh: li.lastSuccessfulRefresh,
+				ControlHost:           li.config.controlHost,
+				AddressType:           addressType(li.flags),
+			})
+		}
+		return nil
+	})
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// ClusterConnectionStats is a snapshot of the distribution of the load balanced connections to a cluster.
+type ClusterConnectionStats struct {
+	Name        string    `json:"name"`
+	LastRefresh time.Time `json:"last_refresh"`
+	// Selections is the number of times a server of the cluster was selected for a connection, see SelectionCounts.
+	Selections int                   `json:"selections"`
+	Hosts      []HostConnectionStats `json:"hosts"`
+}
+
+// HostConnectionStats is the share of a server in the load balanced connections to its cluster.
+type HostConnectionStats struct {
+	Host string `json:"host"`
+	// NodeType is "primary" or "read_replica".
+	NodeType string `json:"node_type"`
+	// Zone is the "cloud.region.zone" of the server.
+	Zone string `json:"zone"`
+	// Connections is the number of open connections to the server, it is not kept while the server is away.
+	Connections int `json:"connections"`
+	// Away is true while no connection is made to the server because it is marked unavailable, AwaySince holding
+	// since when it is marked after failed connects, or because it is excluded by MarkHostUnavailable.
+	Away      bool      `json:"away"`
+	AwaySince time.Time `json:"away_since"`
+	// Selections is the number of times the server was selected for a connection.
+	Selections int `json:"selections"`
+}
+
+// ClusterStats returns a snapshot of the distribution of the load balanced connections to every cluster they were made
+// to with the default ClusterManager, ordered by name. It is meant to be exposed by e.g. an admin endpoint.
+func ClusterStats() []ClusterConnectionStats {
+	return defaultClusterManager.ClusterStats()
+}
+
+// ClusterStats returns a snapshot of the connections made with m, see ClusterStats.
+func (m *ClusterManager) ClusterStats() []ClusterConnectionStats {
+	var stats []ClusterConnectionStats
+	m.inspectLoadInfo(func(clis map[string]*ClusterLoadInfo) error {
+		for _, li := range clis {
+			stats = append(stats, clusterConnectionStats(li))
+		}
+		return nil
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func clusterConnectionStats(li *ClusterLoadInfo) ClusterConnectionStats {
+	stats := ClusterConnectionStats{Name: li.clusterName, LastRefresh: li.lastRefresh}
+	for _, n := range li.selectionCounts {
+		stats.Selections += n
+	}
+	for _, node := range clusterTopology(li) {
+		host := HostConnectionStats{
+			Host:       node.Host,
+			NodeType:   node.NodeType,
+			Away:       !node.Available,
+			AwaySince:  node.UnavailableSince,
+			Selections: li.selectionCounts[node.Host],
+		}
+		if node.Cloud != "" {
+			host.Zone = node.Cloud + "." + node.Region + "." + node.Zone
+		}
+		if cnt, ok := li.hostLoadPrimary[node.Host]; ok {
+			host.Connections = cnt
+		} else if cnt, ok := li.hostLoadRR[node.Host]; ok {
+			host.Connections = cnt
+		}
+		stats.Hosts = append(stats.Hosts, host)
+	}
+	return stats
+}
+
+// Topology is the servers of a cluster as the load balancer currently knows them.
+type Topology struct {
+	ClusterName string         `json:"cluster_name"`
+	Nodes       []TopologyNode `json:"nodes"`
+}
+
+// TopologyNode is a server of a Topology.
+type TopologyNode struct {
+	Host string `json:"host"`
+	Port uint16 `json:"port"`
+	// PublicIP is the public address of the server, it is not known while the server is unavailable.
+	PublicIP string `json:"public_ip"`
+	// NodeType is "primary" or "read_replica".
+	NodeType string `json:"node_type"`
+	Cloud    string `json:"cloud"`
+	Region   string `json:"region"`
+	Zone     string `json:"zone"`
+	// Available is false while the server is marked as unavailable, UnavailableSince holding since when.
+	Available        bool      `json:"available"`
+	UnavailableSince time.Time `json:"unavailable_since"`
+	// Drained is true while the server is excluded by MarkHostUnavailable, until DrainedUntil or, if it is zero, until
+	// UnmarkHost.
+	Drained      bool      `json:"drained"`
+	DrainedUntil time.Time `json:"drained_until"`
+}
+
+// GetClusterTopology returns the servers of the cluster clusterName, the host load balanced connections to it were
+// made with or their cluster_name, as of its last refresh. It returns ErrNoLoadInfo if no such connection was made with the default
+// ClusterManager.
+func GetClusterTopology(clusterName string) (Topology, error) {
+	return defaultClusterManager.Topology(clusterName)
+}
+
+// Topology returns the servers of the cluster clusterName as known by m, see GetClusterTopology.
+func (m *ClusterManager) Topology(clusterName string) (Topology, error) {
+	topology := Topology{ClusterName: m.clusterKey(clusterName)}
+	err := m.withCluster(topology.ClusterName, func(li *ClusterLoadInfo) error {
+		topology.Nodes = clusterTopology(li)
+		return nil
+	})
+	return topology, err
+}
+
+func clusterTopology(li *ClusterLoadInfo) []TopologyNode {
+	// Unlike the load maps, the zone lists keep the servers marked as unavailable.
+	nodeTypes := make(map[string]string)
+	zoneLists := map[string]map[string][]string{"primary": li.zoneListPrimary, "read_replica": li.zoneListRR}
+	for nodeType, zoneList := range zoneLists {
+		for _, hosts := range zoneList {
+			for _, h := range hosts {
+				nodeTypes[h] = nodeType
+			}
+		}
+	}
+	placements := hostPlacements(li)
+	nodes := make([]TopologyNode, 0, len(li.hostPort))
+	for h, port := range li.hostPort {
+		node := TopologyNode{Host: h, Port: port, PublicIP: li.hostPairs[h], NodeType: nodeTypes[h], Available: true}
+		if parts := strings.Split(placements[h], "."); len(parts) == 3 {
+			node.Cloud, node.Region, node.Zone = parts[0], parts[1], parts[2]
+		}
+		if t, ok := li.unavailableHosts[h]; ok {
+			node.Available = false
+			node.UnavailableSince = time.Unix(t, 0)
+		}
+		if isHostDrained(li, h) {
+			node.Available = false
+			node.Drained = true
+			node.DrainedUntil = li.drainedHosts[h]
+			if until, ok := li.drainedHosts[li.hostPairs[h]]; ok {
+				node.DrainedUntil = until
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Host < nodes[j].Host })
+	return nodes
+}
+
+// ColdStartTiming holds the durations of the phases of the first load balanced connect to a cluster, which also
+// creates its load information.
+type ColdStartTiming struct {
+	// DNS is the time taken to resolve the host of the connection string.
+	DNS time.Duration
+	// ControlConnect is the time taken to create the control connection.
+	ControlConnect time.Duration
+	// ServersQuery is the time taken to query and read the servers of the cluster.
+	ServersQuery time.Duration
+	// DataConnect is the time taken to connect to the selected server, including retries on other servers.
+	DataConnect time.Duration
+	// Total is the time the whole connect took.
+	Total time.Duration
+}
+
+func recordColdStart(m *ClusterManager, clusterName string, timing ColdStartTiming) {
+	m.withCluster(clusterName, func(li *ClusterLoadInfo) error {
+		li.lastColdStart = timing
+		return nil
+	})
+}
+
+// LastColdStartTiming returns the phase durations of the first load balanced connect to the cluster connString belongs
+// to. It returns ErrNoLoadInfo if no load balanced connection has been made to that cluster yet, and the zero value if
+// the first connect has not completed yet. Only the connects made with the default ClusterManager are considered.
+func LastColdStartTiming(connString string) (ColdStartTiming, error) {
+	return defaultClusterManager.LastColdStartTiming(connString)
+}
+
+// LastColdStartTiming returns the phase durations of the first connect made by m to the cluster connString belongs
+// to, see LastColdStartTiming.
+func (m *ClusterManager) LastColdStartTiming(connString string) (ColdStartTiming, error) {
+	var timing ColdStartTiming
+	err := m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		timing = li.lastColdStart
+		return nil
+	})
+	return timing, err
+}
+
+// ExplainHostExclusion returns why host gets, or does not get, load balanced connections to the cluster connString
+// belongs to. host may be the private or the public address of a server. Only the connections made with the default
+// ClusterManager are considered.
+func ExplainHostExclusion(connString string, host string) string {
+	return defaultClusterManager.ExplainHostExclusion(connString, host)
+}
+
+// ExplainHostExclusion returns why host gets, or does not get, the connections made by m to the cluster connString
+// belongs to, see ExplainHostExclusion.
+func (m *ClusterManager) ExplainHostExclusion(connString string, host string) string {
+	var reason string
+	err := m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		reason = explainHostExclusion(li, LookupIP(host))
+		return nil
+	})
+	if err != nil {
+		return err.Error()
+	}
+	return reason
+}
+
+func explainHostExclusion(li *ClusterLoadInfo, host string) string {
+	h := host
+	for private, public := range li.hostPairs {
+		if public == host {
+			h = private
+		}
+	}
+	if isHostDrained(li, h) {
+		return fmt.Sprintf("%s is marked unavailable by MarkHostUnavailable", host)
+	}
+	if _, ok := li.unavailableHosts[h]; ok && li.config.probeInterval > 0 {
+		return fmt.Sprintf("%s is marked unavailable until it responds to a probe", host)
+	}
+	if t, ok := li.unavailableHosts[h]; ok {
+		until := time.Unix(t+reconnectDelay(li, h), 0)
+		return fmt.Sprintf("%s is marked unavailable until %s", host, until.Format(time.RFC3339))
+	}
+	nodeType := "primary"
+	if _, ok := li.hostLoadPrimary[h]; !ok {
+		if _, ok := li.hostLoadRR[h]; !ok {
+			return fmt.Sprintf("%s is not in the server list of the cluster", host)
+		}
+		nodeType = "read replica"
+	}
+	if !isHostAllowed(li, h) {
+		return fmt.Sprintf("%s is not in load_balance_allow_hosts", host)
+	}
+	if (li.config.loadBalance == "only-rr" && nodeType == "primary") ||
+		(li.config.loadBalance == "only-primary" && nodeType == "read replica") {
+		return fmt.Sprintf("%s is a %s server, which load_balance=%s excludes", host, nodeType, li.config.loadBalance)
+	}
+	if isHostLagging(li, h) {
+		return fmt.Sprintf("%s lags %dms behind, more than load_balance_max_replica_lag_ms=%d", host,
+			li.replicationLag[h], li.config.maxReplicaLagMs)
+	}
+
+	hostload, eligible, topologyTier, _, err := eligibleHosts(li, false)
+	if err != nil {
+		return fmt.Sprintf("%s does not match topology_keys and fallback_to_topology_keys_only is set", host)
+	}
+	isEligible := false
+	for _, e := range eligible {
+		if e == h {
+			isEligible = true
+		}
+	}
+	if !isEligible {
+		if topologyTier >= 0 {
+			return fmt.Sprintf("%s is excluded by topology_keys, servers of preference %d are available", host, topologyTier+1)
+		}
+		return fmt.Sprintf("%s is excluded by load_balance=%s, servers of the preferred type are available", host,
+			li.config.loadBalance)
+	}
+	if priority := priorityHost(li, eligible); priority == h {
+		return fmt.Sprintf("%s is eligible and the first available of load_balance_priority_hosts", host)
+	} else if priority != "" {
+		return fmt.Sprintf("%s is eligible but %s of load_balance_priority_hosts is preferred", host, priority)
+	}
+	leastCnt, leastLoaded := leastLoadedOf(hostload, eligible)
+	if hostload[h] > leastCnt {
+		return fmt.Sprintf("%s is eligible but not the least loaded, it has %d connections while the least loaded have %d",
+			host, hostload[h], leastCnt)
+	}
+	if local := localHosts(li, leastLoaded); len(local) != 0 && len(localHosts(li, []string{h})) == 0 {
+		return fmt.Sprintf("%s is among the least loaded but the local server %s is preferred", host, local[0])
+	}
+	return fmt.Sprintf("%s is eligible and among the least loaded with %d connections", host, hostload[h])
+}
+
+// TopologyKeysStrictFallbackTotal returns the number of times no server matching topology_keys was available for a
+// connect with fallback_to_topology_keys_only set, across all clusters of the default ClusterManager, see
+// ClusterManager.TopologyKeysStrictFallbackTotal.
+func TopologyKeysStrictFallbackTotal() uint64 {
+	return defaultClusterManager.TopologyKeysStrictFallbackTotal()
+}
+
+// TopologyKeysStrictFallbackTotal returns the number of times no server matching topology_keys was available for a
+// connect with fallback_to_topology_keys_only set, across all clusters of m. Each of them failed the connect with
+// ErrFallbackToOriginalBehaviour.
+func (m *ClusterManager) TopologyKeysStrictFallbackTotal() uint64 {
+	return atomic.LoadUint64(&m.topologyKeysStrictFallbacks)
+}
+
+// DirectConnectStats counts the load balanced connects, across all clusters of a ClusterManager, which were made to
+// the Host of their config without load balancing, by reason.
+type DirectConnectStats struct {
+	// CircuitOpen is the number of connects while the circuit of their cluster was open, see
+	// load_balance_circuit_failures. They do not wait for the control connection of a failing cluster.
+	CircuitOpen uint64
+	// Degraded is the number of connects while their ClusterManager was Degraded.
+	Degraded uint64
+	// NoLoadInfo is the number of connects whose server selection failed, e.g. because no control connection could be
+	// made.
+	NoLoadInfo uint64
+}
+
+// DirectConnectTotals returns the number of load balanced connects made without load balancing with the default
+// ClusterManager, see ClusterManager.DirectConnectTotals.
+func DirectConnectTotals() DirectConnectStats {
+	return defaultClusterManager.DirectConnectTotals()
+}
+
+// DirectConnectTotals returns the number of load balanced connects made without load balancing with m since it was
+// created, a measure of how degraded load balancing is.
+func (m *ClusterManager) DirectConnectTotals() DirectConnectStats {
+	return DirectConnectStats{
+		CircuitOpen: atomic.LoadUint64(&m.directConnects.CircuitOpen),
+		Degraded:    atomic.LoadUint64(&m.directConnects.Degraded),
+		NoLoadInfo:  atomic.LoadUint64(&m.directConnects.NoLoadInfo),
+	}
+}
+
+// Estimated sizes of a copied zone list entry, a string and a slice header, and of a copied host load entry, a string
+// header and an int.
+const zoneEntryBytes = 16 + 24
+const hostLoadEntryBytes = 16 + 8
+
+// SelectionCopyStats is the cost of copying the zone lists and connection counts of a cluster, which every server
+// selection does, accumulated since EnableSelectionCopyStats.
+type SelectionCopyStats struct {
+	// Selections is the number of server selections.
+	Selections int64
+	// Entries is the number of map entries copied.
+	Entries int64
+	// Bytes is the estimated size of the entries copied, not counting the maps themselves.
+	Bytes int64
+	// Duration is the time spent copying.
+	Duration time.Duration
+}
+
+var selectionCopyStatsEnabled int32
+
+var selectionCopyStats struct {
+	sync.Mutex
+	stats SelectionCopyStats
+}
+
+// EnableSelectionCopyStats starts or stops recording SelectionCopyStats. Starting it resets the stats. It is meant
+// for debugging, as it adds a little overhead to every server selection.
+func EnableSelectionCopyStats(enabled bool) {
+	selectionCopyStats.Lock()
+	defer selectionCopyStats.Unlock()
+	if enabled {
+		selectionCopyStats.stats = SelectionCopyStats{}
+		atomic.StoreInt32(&selectionCopyStatsEnabled, 1)
+	} else {
+		atomic.StoreInt32(&selectionCopyStatsEnabled, 0)
+	}
+}
+
+// GetSelectionCopyStats returns the stats recorded since EnableSelectionCopyStats.
+func GetSelectionCopyStats() SelectionCopyStats {
+	selectionCopyStats.Lock()
+	defer selectionCopyStats.Unlock()
+	return selectionCopyStats.stats
+}
+
+func recordSelectionCopy(d time.Duration, zoneEntries int, hostLoadEntries int) {
+	selectionCopyStats.Lock()
+	defer selectionCopyStats.Unlock()
+	stats := &selectionCopyStats.stats
+	stats.Selections++
+	stats.Entries += int64(zoneEntries + hostLoadEntries)
+	stats.Bytes += int64(zoneEntries*zoneEntryBytes + hostLoadEntries*hostLoadEntryBytes)
+	stats.Duration += d
+}
+
+// LoadImbalanceRatio returns the ratio of the connection count of the most loaded server to the one of the least
+// loaded server among the servers connects to the cluster of connString are currently balanced across. It is 1 for
+// a perfectly balanced cluster, and +Inf if some servers have connections while others have none. It returns
+// ErrNoLoadInfo if no load balanced connection was made to the cluster with the default ClusterManager.
+func LoadImbalanceRatio(connString string) (float64, error) {
+	return defaultClusterManager.LoadImbalanceRatio(connString)
+}
+
+// LoadImbalanceRatio returns the load imbalance ratio of the cluster connString belongs to as known by m, see
+// LoadImbalanceRatio.
+func (m *ClusterManager) LoadImbalanceRatio(connString string) (float64, error) {
+	var ratio float64
+	err := m.inspectCluster(connString, func(li *ClusterLoadInfo) error {
+		var err error
+		ratio, err = loadImbalanceRatio(li)
+		return err
+	})
+	return ratio, err
+}
+
+// LoadImbalanceRatio returns LoadImbalanceRatio for the cluster of a load balanced connection, 0 if the connection is
+// not load balanced or the ratio cannot be computed.
+func (c *Conn) LoadImbalanceRatio() float64 {
+	if c.lbConfig == nil {
+		return 0
+	}
+	var ratio float64
+	err := c.lbConfig.clusterManager().inspectCluster(c.lbConfig.connString, func(li *ClusterLoadInfo) error {
+		var err error
+		ratio, err = loadImbalanceRatio(li)
+		return err
+	})
+	if err != nil {
+		return 0
+	}
+	return ratio
+}
+
+func loadImbalanceRatio(li *ClusterLoadInfo) (float64, error) {
+	hostLoad, eligible, _, _, err := eligibleHosts(li, false)
+	if err != nil {
+		return 0, err
+	}
+	if len(eligible) == 0 {
+		return 0, ErrNoServersAvailable
+	}
+	least, most := math.MaxInt, 0
+	for _, h := range eligible {
+		if hostLoad[h] < least {
+			least = hostLoad[h]
+		}
+		if hostLoad[h] > most {
+			most = hostLoad[h]
+		}
+	}
+	if most == 0 {
+		return 1, nil
+	}
+	return float64(most) / float64(least), nil
+}
@@ -0,0 +1,158 @@
+package pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/yugabyte/pgx/v5/pgconn"
+)
+
+// TABLET_LEADERS_QUERY lists the hash ranges of the tablets of the tables of the database with the "host:port" of
+// their leaders, for load_balance_tablet_aware. yb_table_properties only tells the number of tablets of a table, not
+// where their leaders are.
+const TABLET_LEADERS_QUERY = "SELECT relname,start_hash_code,end_hash_code,leader FROM yb_tablet_metadata " +
+	"WHERE db_name = current_database()"
+
+// SHARD_KEY_TYPES_QUERY lists the types of the primary key columns of the table $1, in the order of the key.
+const SHARD_KEY_TYPES_QUERY = "SELECT format_type(a.atttypid, a.atttypmod) FROM pg_index i " +
+	"JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey) " +
+	"WHERE i.indrelid = $1::regclass AND i.indisprimary ORDER BY array_position(i.indkey::int2[], a.attnum)"
+
+// Maximum number of shard keys whose yb_hash_code() is cached per cluster, the cache is emptied when it is reached.
+const SHARD_KEY_CACHE_SIZE = 10000
+
+type shardKeyCtxKey struct{}
+
+type shardKey struct {
+	table string
+	key   []any
+}
+
+// WithShardKey returns a copy of ctx that makes a load balanced connect with it select the server of the leader of
+// the tablet holding the row of table whose hash columns of the primary key are key, if load_balance_tablet_aware is
+// set. The single row reads and writes of the connection then save the hop from the server to the tablet leader. The
+// values of key are converted to the types of the columns, so e.g. an int can be given for a bigint column. If the
+// leader is not known or is not an eligible server, the server is selected as without it.
+func WithShardKey(ctx context.Context, table string, key ...any) context.Context {
+	return context.WithValue(ctx, shardKeyCtxKey{}, shardKey{table: table, key: key})
+}
+
+// tabletRange is a tablet of a table, which holds the rows whose hash code is in [start, end).
+type tabletRange struct {
+	start, end int
+	// host of the leader of the tablet
+	leader string
+}
+
+// isUndefinedTable tells if err is the error of a query naming a table which does not exist.
+func isUndefinedTable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42P01"
+}
+
+// refreshTabletLeaders queries the tablet leaders of the database of li over its control connection. The previous
+// ones are kept if it fails.
+func refreshTabletLeaders(li *ClusterLoadInfo) {
+	rows, err := li.controlConn.Query(li.ctrlCtx, TABLET_LEADERS_QUERY)
+	if isUndefinedTable(err) {
+		lbLogf(li.config, LBLogLevelWarn, "The server has no yb_tablet_metadata, connects are not tablet aware")
+		li.noTabletMetadata = true
+		li.tabletLeaders = nil
+		return
+	}
+	if err != nil {
+		lbLogf(li.config, LBLogLevelWarn, "Could not query tablet leaders: %s", redactSecrets(err.Error()))
+		return
+	}
+	var relname string
+	var start, end *int
+	var leader *string
+	tabletLeaders := make(map[string][]tabletRange)
+	_, err = ForEachRow(rows, []any{&relname, &start, &end, &leader}, func() error {
+		if start == nil || end == nil || leader == nil {
+			return nil // a range sharded table, or a tablet without leader
+		}
+		h, _, err := net.SplitHostPort(*leader)
+		if err != nil {
+			h = *leader
+		}
+		if h = privateHost(li, LookupIP(h)); h != "" {
+			tabletLeaders[relname] = append(tabletLeaders[relname], tabletRange{start: *start, end: *end, leader: h})
+		}
+		return nil
+	})
+	if err != nil {
+		lbLogf(li.config, LBLogLevelWarn, "Could not read tablet leaders: %s", redactSecrets(err.Error()))
+		return
+	}
+	li.tabletLeaders = tabletLeaders
+}
+
+// tabletLeader returns the host of the leader of the tablet of the WithShardKey hint of the connect if it is one of
+// hosts. It returns "" if there is no hint, the leader is not known or is not one of hosts.
+func tabletLeader(li *ClusterLoadInfo, hosts []string) string {
+	sk, ok := li.ctx.Value(shardKeyCtxKey{}).(shardKey)
+	if !ok || !li.config.tabletAware {
+		return ""
+	}
+	tablets := li.tabletLeaders[sk.table[strings.LastIndex(sk.table, ".")+1:]]
+	if len(tablets) == 0 {
+		return ""
+	}
+	code, ok := shardHashCode(li, sk)
+	if !ok {
+		return ""
+	}
+	for _, t := range tablets {
+		if code < t.start || code >= t.end {
+			continue
+		}
+		for _, h := range hosts {
+			if h == t.leader {
+				return h
+			}
+		}
+		lbLogf(li.config, LBLogLevelDebug, "Leader %s of the tablet of the shard key is not eligible", t.leader)
+		return ""
+	}
+	return ""
+}
+
+// shardHashCode returns the yb_hash_code() of the key of sk, queried over the control connection of li the first time.
+func shardHashCode(li *ClusterLoadInfo, sk shardKey) (int, bool) {
+	cacheKey := sk.table + "\x00" + fmt.Sprintf("%#v", sk.key)
+	if code, ok := li.shardHashCodes[cacheKey]; ok {
+		return code, true
+	}
+	if li.controlConn == nil || li.controlConn.IsClosed() {
+		return 0, false
+	}
+	ctx, cancel := controlContext(li)
+	defer cancel()
+	rows, _ := li.controlConn.Query(ctx, SHARD_KEY_TYPES_QUERY, sk.table)
+	types, err := CollectRows(rows, RowTo[string])
+	if err == nil && len(types) < len(sk.key) {
+		err = fmt.Errorf("table %s has %d primary key columns, not %d", sk.table, len(types), len(sk.key))
+	}
+	var code int
+	if err == nil {
+		args := make([]string, len(sk.key))
+		for i := range sk.key {
+			args[i] = "$" + strconv.Itoa(i+1) + "::" + types[i]
+		}
+		err = li.controlConn.QueryRow(ctx, "SELECT yb_hash_code("+strings.Join(args, ",")+")", sk.key...).Scan(&code)
+	}
+	if err != nil {
+		lbLogf(li.config, LBLogLevelWarn, "Could not hash the shard key of %s: %s", sk.table, redactSecrets(err.Error()))
+		return 0, false
+	}
+	if li.shardHashCodes == nil || len(li.shardHashCodes) >= SHARD_KEY_CACHE_SIZE {
+		li.shardHashCodes = make(map[string]int)
+	}
+	li.shardHashCodes[cacheKey] = code
+	return code, true
+}
@@ -0,0 +1,91 @@
+package pgx
+
+import (
+	"errors"
+
+	"github.com/yugabyte/pgx/v5/pgconn"
+)
+
+// Column of yb_servers() holding the permanent identifier of a server, which it keeps when its address changes, e.g.
+// when its Kubernetes pod is rescheduled. It is not reported by older YugabyteDB versions.
+const UUID_COLUMN = "uuid"
+
+// LB_QUERY_WITHOUT_UUID is run instead of LB_QUERY on the clusters whose yb_servers() has no UUID_COLUMN.
+const LB_QUERY_WITHOUT_UUID = "SELECT host,port,num_connections,node_type,cloud,region,zone,public_ip FROM yb_servers()"
+
+// isUndefinedColumn tells if err is the error of a query naming a column which does not exist.
+func isUndefinedColumn(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42703"
+}
+
+// renamedFrom returns the host the server with uuid was listed at by the previous refresh of li, if it was listed at
+// another host than host, the one it is listed at now, and host was not listed then.
+func renamedFrom(li *ClusterLoadInfo, previousHosts map[string]string, uuid string, host string) (string, bool) {
+	previous, ok := previousHosts[uuid]
+	if !ok || previous == host {
+		return "", false
+	}
+	if _, listed := li.hostPort[host]; listed {
+		return "", false
+	}
+	return previous, true
+}
+
+// renameHost moves the state kept for the server at from to to, its new address, so that the server keeps being
+// marked unavailable, drained or quarantined, and its latencies and selections. The connections counted against from
+// are decremented from to when they are closed.
+func renameHost(li *ClusterLoadInfo, from string, to string) {
+	lbLogf(li.config, LBLogLevelInfo, "Server %s moved to %s", from, to)
+	moveHostEntry(li.unavailableHosts, from, to)
+	moveHostEntry(li.drainedHosts, from, to)
+	moveHostEntry(li.quarantinedUntil, from, to)
+	moveHostEntry(li.probes, from, to)
+	moveHostEntry(li.latencyAverages, from, to)
+	moveHostEntry(li.connectLatencies, from, to)
+	moveHostEntry(li.selectionCounts, from, to)
+	moveHostEntry(li.lastSelected, from, to)
+	moveHostEntry(li.joinedAt, from, to)
+	moveHostEntry(li.dataConnectFailures, from, to)
+	moveHostEntry(li.asymmetricHosts, from, to)
+	moveHostEntry(li.awayCounts, from, to)
+	moveHostEntry(li.reconnectDelays, from, to)
+	if li.renamedHosts == nil {
+		li.renamedHosts = make(map[string]string)
+	}
+	for old, current := range li.renamedHosts {
+		if current == from {
+			li.renamedHosts[old] = to
+		}
+	}
+	li.renamedHosts[from] = to
+	delete(li.renamedHosts, to)
+}
+
+// moveHostEntry moves the entry of from in m, if any, to to.
+func moveHostEntry[V any](m map[string]V, from string, to string) {
+	if v, ok := m[from]; ok {
+		m[to] = v
+		delete(m, from)
+	}
+}
+
+// pruneRenamedHosts forgets the former addresses of the servers which are no longer listed, or are listed at their
+// former address again.
+func pruneRenamedHosts(li *ClusterLoadInfo) {
+	for old, current := range li.renamedHosts {
+		_, currentListed := li.hostPort[current]
+		_, oldListed := li.hostPort[old]
+		if !currentListed || oldListed {
+			delete(li.renamedHosts, old)
+		}
+	}
+}
+
+// currentHost returns the address the server at h is at now, h itself unless the server moved.
+func currentHost(li *ClusterLoadInfo, h string) string {
+	if current, ok := li.renamedHosts[h]; ok {
+		return current
+	}
+	return h
+}
@@ -0,0 +1,252 @@
+package pgxpool_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/internal/ybmock"
+	"github.com/yugabyte/pgx/v5/pgxpool"
+)
+
+// hostConnections returns the connection counts kept against the servers of the cluster clusterName.
+func hostConnections(clusterName string) map[string]int {
+	counts := make(map[string]int)
+	for _, cluster := range pgx.DumpLoadBalancerState().Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		for _, h := range cluster.Hosts {
+			counts[h.Host] = h.Connections
+		}
+	}
+	return counts
+}
+
+func TestPoolRebalancesOnAddedServer(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	first := cluster.Nodes[0]
+
+	connString := cluster.ConnString("")
+	config, err := pgxpool.ParseConfig(connString + "&pool_min_conns=4&pool_max_conns=4&pool_rebalance_interval=50ms")
+	require.NoError(t, err)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer pool.Close()
+	require.Eventually(t, func() bool {
+		return hostConnections(first.Host)[first.Host] == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The refresh reports the added server to the pool.
+	second := cluster.AddNode("primary", "aws.us-east-1.us-east-1b")
+	require.NoError(t, pool.RefreshTopology(context.Background()))
+
+	require.Eventually(t, func() bool {
+		counts := hostConnections(first.Host)
+		return counts[first.Host] == 2 && counts[second.Host] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.EqualValues(t, 2, pool.Stat().RebalanceDestroyCount())
+}
+
+func TestPoolRefreshTopologyWithClusterName(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	first := cluster.Nodes[0]
+	clusterName := "pool-" + first.Host
+
+	connString := cluster.ConnString("cluster_name=" + clusterName)
+	config, err := pgxpool.ParseConfig(connString + "&pool_min_conns=4&pool_max_conns=4&pool_rebalance_interval=50ms")
+	require.NoError(t, err)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer pool.Close()
+	require.Eventually(t, func() bool {
+		return hostConnections(clusterName)[first.Host] == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The cluster is refreshed under its name, and the pool rebalances on the events of that name.
+	second := cluster.AddNode("primary", "aws.us-east-1.us-east-1b")
+	require.NoError(t, pool.RefreshTopology(context.Background()))
+	require.Eventually(t, func() bool {
+		counts := hostConnections(clusterName)
+		return counts[first.Host] == 2 && counts[second.Host] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPoolRebalancesWithClusterManager(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	first := cluster.Nodes[0]
+	m := pgx.NewClusterManager()
+	defer m.Shutdown()
+	hostConnections := func() map[string]int {
+		counts := make(map[string]int)
+		for _, c := range m.State().Clusters {
+			for _, h := range c.Hosts {
+				counts[h.Host] = h.Connections
+			}
+		}
+		return counts
+	}
+
+	config, err := pgxpool.ParseConfig(cluster.ConnString("") +
+		"&pool_min_conns=4&pool_max_conns=4&pool_rebalance_interval=50ms")
+	require.NoError(t, err)
+	config.ConnConfig.ClusterManager = m
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer pool.Close()
+	require.Eventually(t, func() bool {
+		return hostConnections()[first.Host] == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The pool rebalances on the events of its ClusterManager.
+	second := cluster.AddNode("primary", "aws.us-east-1.us-east-1b")
+	require.NoError(t, pool.RefreshTopology(context.Background()))
+	require.Eventually(t, func() bool {
+		counts := hostConnections()
+		return counts[first.Host] == 2 && counts[second.Host] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.EqualValues(t, 2, pool.Stat().RebalanceDestroyCount())
+}
+
+func TestPoolClosesIdleConnsToMarkedHost(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b")
+	first, second := cluster.Nodes[0], cluster.Nodes[1]
+
+	connString := cluster.ConnString("")
+	config, err := pgxpool.ParseConfig(connString + "&pool_min_conns=4&pool_max_conns=4&pool_rebalance_interval=50ms")
+	require.NoError(t, err)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer pool.Close()
+	require.Eventually(t, func() bool {
+		counts := hostConnections(first.Host)
+		return counts[first.Host] == 2 && counts[second.Host] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, pgx.MarkHostUnavailable(first.Host, second.Host, 0))
+	defer pgx.UnmarkHost(first.Host, second.Host)
+
+	require.Eventually(t, func() bool {
+		counts := hostConnections(first.Host)
+		return counts[first.Host] == 4 && counts[second.Host] == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.EqualValues(t, 2, pool.Stat().RebalanceDestroyCount())
+}
+
+func TestPoolAcquireWithNodePreference(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	rr := cluster.AddNode("read_replica", "aws.us-east-1.us-east-1b")
+
+	connString := strings.Replace(cluster.ConnString(""), "load_balance=true", "load_balance=only-primary", 1)
+	config, err := pgxpool.ParseConfig(connString + "&pool_max_conns=4")
+	require.NoError(t, err)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	read, err := pool.Acquire(pgx.WithNodePreference(context.Background(), pgx.PreferReadReplica))
+	require.NoError(t, err)
+	assert.Equal(t, rr.Host, read.Conn().LoadBalanceInfo().Host)
+	write, err := pool.Acquire(pgx.WithNodePreference(context.Background(), pgx.PreferPrimary))
+	require.NoError(t, err)
+	assert.Equal(t, "primary", write.Conn().LoadBalanceInfo().NodeType)
+	write.Release()
+	read.Release()
+
+	// The idle connections are reused for their type of server.
+	for i := 0; i < 3; i++ {
+		read, err = pool.Acquire(pgx.WithNodePreference(context.Background(), pgx.PreferReadReplica))
+		require.NoError(t, err)
+		assert.Equal(t, rr.Host, read.Conn().LoadBalanceInfo().Host)
+		read.Release()
+	}
+	assert.EqualValues(t, 2, pool.Stat().NewConnsCount())
+}
+
+func TestPoolAcquireWithNodePreferenceWithoutPreferredServer(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+
+	config, err := pgxpool.ParseConfig(cluster.ConnString("") + "&pool_max_conns=4")
+	require.NoError(t, err)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// Without read replica, the acquires reuse the connection to the primary rather than making more of them.
+	for i := 0; i < 4; i++ {
+		conn, err := pool.Acquire(pgx.WithNodePreference(context.Background(), pgx.PreferReadReplica))
+		require.NoError(t, err)
+		assert.Equal(t, "primary", conn.Conn().LoadBalanceInfo().NodeType)
+		conn.Release()
+	}
+	assert.EqualValues(t, 1, pool.Stat().NewConnsCount())
+	assert.EqualValues(t, 1, pool.Stat().TotalConns())
+}
+
+func TestReadWritePool(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	rr := cluster.AddNode("read_replica", "aws.us-east-1.us-east-1b")
+
+	pool, err := pgxpool.NewReadWritePool(context.Background(), cluster.ConnString(""))
+	require.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Exec(context.Background(), "insert")
+	require.NoError(t, err)
+	_, err = pool.Exec(pgxpool.ReadOnly(context.Background()), "select")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, pool.Primary().Stat().TotalConns())
+	assert.EqualValues(t, 1, pool.ReadReplica().Stat().TotalConns())
+
+	for _, tt := range []struct {
+		ctx       context.Context
+		txOptions pgx.TxOptions
+		host      string
+	}{
+		{context.Background(), pgx.TxOptions{}, cluster.Nodes[0].Host},
+		{context.Background(), pgx.TxOptions{AccessMode: pgx.ReadWrite}, cluster.Nodes[0].Host},
+		{context.Background(), pgx.TxOptions{AccessMode: pgx.ReadOnly}, rr.Host},
+		{pgxpool.ReadOnly(context.Background()), pgx.TxOptions{}, rr.Host},
+	} {
+		tx, err := pool.BeginTx(tt.ctx, tt.txOptions)
+		require.NoError(t, err)
+		assert.Equal(t, tt.host, tx.Conn().LoadBalanceInfo().Host, tt.txOptions)
+		require.NoError(t, tx.Rollback(context.Background()))
+	}
+
+	// Nothing is routed to the read replicas once there are none.
+	cluster.RemoveNode(rr)
+	pool.ReadReplica().Reset()
+	require.NoError(t, pool.ReadReplica().RefreshTopology(context.Background()))
+	_, err = pool.Exec(pgxpool.ReadOnly(context.Background()), "select")
+	require.ErrorIs(t, err, pgx.ErrNoServersAvailable)
+	_, err = pool.Exec(context.Background(), "insert")
+	require.NoError(t, err)
+}
+
+func TestPoolDestroyedConnsReleaseLoadCounts(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b")
+	first, second := cluster.Nodes[0], cluster.Nodes[1]
+
+	connString := cluster.ConnString("")
+	config, err := pgxpool.ParseConfig(connString + "&pool_min_conns=4&pool_max_conns=4&pool_max_conn_lifetime=100ms" +
+		"&pool_max_conn_lifetime_jitter=0s&pool_health_check_period=20ms")
+	require.NoError(t, err)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// The connections destroyed once they expired no longer count, only the ones replacing them do.
+	require.Eventually(t, func() bool {
+		counts := hostConnections(first.Host)
+		return pool.Stat().MaxLifetimeDestroyCount() >= 4 &&
+			counts[first.Host]+counts[second.Host] == int(pool.Stat().TotalConns())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pool.Close()
+	assert.Equal(t, map[string]int{first.Host: 0, second.Host: 0}, hostConnections(first.Host))
+}
@@ -75,9 +75,10 @@ func (cr *connResource) getPoolRows(c *Conn, r pgx.Rows) *poolRows {
 type Pool struct {
 	// 64 bit fields accessed with atomics must be at beginning of struct to guarantee alignment for certain 32-bit
 	// architectures. See BUGS section of https://pkg.go.dev/sync/atomic and https://github.com/jackc/pgx/issues/1288.
-	newConnsCount        int64
-	lifetimeDestroyCount int64
-	idleDestroyCount     int64
+	newConnsCount         int64
+	lifetimeDestroyCount  int64
+	idleDestroyCount      int64
+	rebalanceDestroyCount int64
 
 	p                     *puddle.Pool[*connResource]
 	config                *Config
@@ -92,6 +93,7 @@ type Pool struct {
 	maxConnLifetimeJitter time.Duration
 	maxConnIdleTime       time.Duration
 	healthCheckPeriod     time.Duration
+	rebalanceInterval     time.Duration
 
 	healthCheckChan chan struct{}
 
@@ -147,6 +149,12 @@ type Config struct {
 	// HealthCheckPeriod is the duration between checks of the health of idle connections.
 	HealthCheckPeriod time.Duration
 
+	// RebalanceInterval is the duration between closes of idle load balanced connections whose server the load balancer
+	// would no longer select, see pgx.Conn.NeedsRebalance. Connections are only checked once the servers of the cluster
+	// changed, e.g. by pgx.MarkHostUnavailable, and at most one is closed per interval so that the pool gradually
+	// converges to a balanced distribution. Zero, the default, disables rebalancing.
+	RebalanceInterval time.Duration
+
 	createdByParseConfig bool // Used to enforce created by ParseConfig rule.
 }
 
@@ -194,6 +202,7 @@ func NewWithConfig(ctx context.Context, config *Config) (*Pool, error) {
 		maxConnLifetimeJitter: config.MaxConnLifetimeJitter,
 		maxConnIdleTime:       config.MaxConnIdleTime,
 		healthCheckPeriod:     config.HealthCheckPeriod,
+		rebalanceInterval:     config.RebalanceInterval,
 		healthCheckChan:       make(chan struct{}, 1),
 		closeChan:             make(chan struct{}),
 	}
@@ -250,20 +259,8 @@ func NewWithConfig(ctx context.Context, config *Config) (*Pool, error) {
 
 				return cr, nil
 			},
-			Destructor: func(value *connResource) {
-				ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-				conn := value.conn
-				if p.beforeClose != nil {
-					p.beforeClose(conn)
-				}
-				conn.Close(ctx)
-				select {
-				case <-conn.PgConn().CleanupDone():
-				case <-ctx.Done():
-				}
-				cancel()
-			},
-			MaxSize: config.MaxConns,
+			Destructor: p.destructConn,
+			MaxSize:    config.MaxConns,
 		},
 	)
 	if err != nil {
@@ -275,9 +272,36 @@ func NewWithConfig(ctx context.Context, config *Config) (*Pool, error) {
 		p.backgroundHealthCheck()
 	}()
 
+	if p.rebalanceInterval > 0 {
+		// The events are those of the ClusterManager the connections of the pool are load balanced with.
+		if m := config.ConnConfig.ClusterManager; m != nil {
+			go p.backgroundRebalance(m.SubscribeLoadBalancerEvents(), m.UnsubscribeLoadBalancerEvents,
+				config.ConnConfig.ClusterName())
+		} else {
+			go p.backgroundRebalance(pgx.SubscribeLoadBalancerEvents(), pgx.UnsubscribeLoadBalancerEvents,
+				config.ConnConfig.ClusterName())
+		}
+	}
+
 	return p, nil
 }
 
+// destructConn closes the connection of a destroyed resource, which decrements the count kept against its server by
+// the load balancer whatever destroyed it: a health check, its lifetime, a rebalance or Close.
+func (p *Pool) destructConn(value *connResource) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	conn := value.conn
+	if p.beforeClose != nil {
+		p.beforeClose(conn)
+	}
+	conn.Close(ctx)
+	select {
+	case <-conn.PgConn().CleanupDone():
+	case <-ctx.Done():
+	}
+	cancel()
+}
+
 // ParseConfig builds a Config from connString. It parses connString with the same behavior as [pgx.ParseConfig] with the
 // addition of the following variables:
 //
@@ -287,6 +311,7 @@ func NewWithConfig(ctx context.Context, config *Config) (*Pool, error) {
 //   - pool_max_conn_idle_time: duration string
 //   - pool_health_check_period: duration string
 //   - pool_max_conn_lifetime_jitter: duration string
+//   - pool_rebalance_interval: duration string
 //
 // See Config for definitions of these arguments.
 //
@@ -376,6 +401,15 @@ func ParseConfig(connString string) (*Config, error) {
 		config.MaxConnLifetimeJitter = d
 	}
 
+	if s, ok := config.ConnConfig.Config.RuntimeParams["pool_rebalance_interval"]; ok {
+		delete(connConfig.Config.RuntimeParams, "pool_rebalance_interval")
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool_rebalance_interval: %w", err)
+		}
+		config.RebalanceInterval = d
+	}
+
 	return config, nil
 }
 
@@ -468,6 +502,66 @@ func (p *Pool) checkConnsHealth() bool {
 	return destroyed
 }
 
+// backgroundRebalance closes the idle connections needing to be rebalanced, one every rebalanceInterval, after events
+// signals that servers were added to or removed from the cluster clusterName or that a server recovered. It
+// unsubscribes from events with unsubscribe once the pool is closed.
+func (p *Pool) backgroundRebalance(events <-chan pgx.LBEvent, unsubscribe func(<-chan pgx.LBEvent),
+	clusterName string,
+) {
+	defer unsubscribe(events)
+	var ticker *time.Ticker
+	var tick <-chan time.Time // nil unless rebalancing
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-p.closeChan:
+			return
+		case e := <-events:
+			if e.ClusterName != clusterName || ticker != nil {
+				continue
+			}
+			if (e.Type == pgx.LBEventRefreshed && len(e.Added)+len(e.Removed) > 0) ||
+				e.Type == pgx.LBEventHostRecovered || e.Type == pgx.LBEventHostMarkedAway {
+				ticker = time.NewTicker(p.rebalanceInterval)
+				tick = ticker.C
+			}
+		case <-tick:
+			if !p.rebalanceIdleConn() {
+				ticker.Stop()
+				ticker, tick = nil, nil
+			}
+		}
+	}
+}
+
+// rebalanceIdleConn destroys an idle connection needing to be rebalanced, if there is one, and reports whether it did.
+// Unlike Destroy, it closes the connection before returning and replaces it as needed to keep minConns connections, so
+// that the next connection is checked against up to date connection counts.
+func (p *Pool) rebalanceIdleConn() bool {
+	var rebalanced *puddle.Resource[*connResource]
+	resources := p.p.AcquireAllIdle()
+	for _, res := range resources {
+		if rebalanced == nil && res.Value().conn.NeedsRebalance() {
+			rebalanced = res
+		} else {
+			res.ReleaseUnused()
+		}
+	}
+	if rebalanced == nil {
+		return false
+	}
+	cr := rebalanced.Value()
+	rebalanced.Hijack()
+	p.destructConn(cr)
+	atomic.AddInt64(&p.rebalanceDestroyCount, 1)
+	p.checkMinConns()
+	return true
+}
+
 func (p *Pool) checkMinConns() error {
 	// TotalConns can include ones that are being destroyed but we should have
 	// sleep(500ms) around all of the destroys to help prevent that from throwing
@@ -508,7 +602,8 @@ func (p *Pool) createIdleResources(parentCtx context.Context, targetResources in
 	return firstError
 }
 
-// Acquire returns a connection (*Conn) from the Pool
+// Acquire returns a connection (*Conn) from the Pool. If ctx was given a preference by pgx.WithNodePreference, a
+// connection to a server of the preferred type is returned if the pool has or can create one.
 func (p *Pool) Acquire(ctx context.Context) (c *Conn, err error) {
 	if p.acquireTracer != nil {
 		ctx = p.acquireTracer.TraceAcquireStart(ctx, p, TraceAcquireStartData{})
@@ -521,6 +616,12 @@ func (p *Pool) Acquire(ctx context.Context) (c *Conn, err error) {
 		}()
 	}
 
+	if pref, ok := pgx.NodePreferenceFromContext(ctx); ok {
+		if c := p.acquirePreferred(ctx, pref); c != nil {
+			return c, nil
+		}
+	}
+
 	for {
 		res, err := p.p.Acquire(ctx)
 		if err != nil {
@@ -545,6 +646,73 @@ func (p *Pool) Acquire(ctx context.Context) (c *Conn, err error) {
 	}
 }
 
+// acquirePreferred returns an idle connection to a server of the type pref prefers. If there is none, it creates at
+// most one connection, if the pool is not full and the cluster has an available server of that type. It returns nil if
+// there is no such connection, in which case any connection is acquired instead.
+func (p *Pool) acquirePreferred(ctx context.Context, pref pgx.NodePreference) *Conn {
+	if c := p.acquireIdlePreferred(ctx, pref); c != nil || !p.hasAvailableServer(pref) {
+		return c
+	}
+	// The connection is made with ctx, so the load balancer selects a server of the preferred type.
+	if err := p.p.CreateResource(ctx); err != nil {
+		return nil
+	}
+	return p.acquireIdlePreferred(ctx, pref)
+}
+
+// acquireIdlePreferred acquires the idle connections one at a time until one to a server of the type pref prefers
+// passes the checks of Acquire. The connections to other servers are put back once it returns, without changing their
+// idle time.
+func (p *Pool) acquireIdlePreferred(ctx context.Context, pref pgx.NodePreference) *Conn {
+	var skipped []*puddle.Resource[*connResource]
+	defer func() {
+		for _, res := range skipped {
+			res.ReleaseUnused()
+		}
+	}()
+	for p.p.Stat().IdleResources() > 0 {
+		res, err := p.p.TryAcquire(ctx)
+		if err != nil {
+			return nil
+		}
+		cr := res.Value()
+		if info := cr.conn.LoadBalanceInfo(); info != nil && info.NodeType != "" && info.NodeType != pref.NodeType() {
+			skipped = append(skipped, res)
+			continue
+		}
+		if res.IdleDuration() > time.Second && cr.conn.Ping(ctx) != nil {
+			res.Destroy()
+			continue
+		}
+		if p.beforeAcquire == nil || p.beforeAcquire(ctx, cr.conn) {
+			return cr.getConn(p, res)
+		}
+		res.Destroy()
+	}
+	return nil
+}
+
+// hasAvailableServer reports whether the cluster of the pool has an available server of the type pref prefers, or
+// whether it is not known yet.
+func (p *Pool) hasAvailableServer(pref pgx.NodePreference) bool {
+	var topology pgx.Topology
+	var err error
+	if m := p.config.ConnConfig.ClusterManager; m != nil {
+		topology, err = m.Topology(p.config.ConnConfig.ClusterName())
+	} else {
+		topology, err = pgx.GetClusterTopology(p.config.ConnConfig.ClusterName())
+	}
+	if err != nil {
+		return true
+	}
+	for _, node := range topology.Nodes {
+		if node.Available && node.NodeType == pref.NodeType() {
+			return true
+		}
+	}
+	return false
+}
+
 // AcquireFunc acquires a *Conn and calls f with that *Conn. ctx will only affect the Acquire. It has no effect on the
 // call of f. The return value is either an error acquiring the *Conn or the return value of f. The *Conn is
 // automatically released after the call of f.
@@ -584,16 +752,26 @@ func (p *Pool) Reset() {
 	p.p.Reset()
 }
 
+// RefreshTopology refreshes the servers of the cluster the pool makes load balanced connections to right away, see
+// pgx.RefreshClusterInfo. It returns pgx.ErrNoLoadInfo if the pool has not made any yet.
+func (p *Pool) RefreshTopology(ctx context.Context) error {
+	if m := p.config.ConnConfig.ClusterManager; m != nil {
+		return m.RefreshClusterInfo(ctx, p.config.ConnConfig.ClusterName())
+	}
+	return pgx.RefreshClusterInfo(ctx, p.config.ConnConfig.ClusterName())
+}
+
 // Config returns a copy of config that was used to initialize this pool.
 func (p *Pool) Config() *Config { return p.config.Copy() }
 
 // Stat returns a pgxpool.Stat struct with a snapshot of Pool statistics.
 func (p *Pool) Stat() *Stat {
 	return &Stat{
-		s:                    p.p.Stat(),
-		newConnsCount:        atomic.LoadInt64(&p.newConnsCount),
-		lifetimeDestroyCount: atomic.LoadInt64(&p.lifetimeDestroyCount),
-		idleDestroyCount:     atomic.LoadInt64(&p.idleDestroyCount),
+		s:                     p.p.Stat(),
+		newConnsCount:         atomic.LoadInt64(&p.newConnsCount),
+		lifetimeDestroyCount:  atomic.LoadInt64(&p.lifetimeDestroyCount),
+		idleDestroyCount:      atomic.LoadInt64(&p.idleDestroyCount),
+		rebalanceDestroyCount: atomic.LoadInt64(&p.rebalanceDestroyCount),
 	}
 }
 
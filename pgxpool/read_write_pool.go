@@ -0,0 +1,114 @@
+package pgxpool
+
+import (
+	"context"
+
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/pgconn"
+)
+
+type readOnlyCtxKey struct{}
+
+// ReadOnly returns a copy of ctx that makes a ReadWritePool run the queries and transactions given it on a read
+// replica.
+func ReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyCtxKey{}, true)
+}
+
+func isReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyCtxKey{}).(bool)
+	return readOnly
+}
+
+// ReadWritePool splits the queries to a cluster between a pool of connections to its primary servers, made with
+// load_balance=only-primary, and a pool of connections to its read replicas, made with load_balance=only-rr. Queries
+// run on the primaries unless their context was given by ReadOnly, and transactions unless their access mode is
+// pgx.ReadOnly. The queries routed to the read replicas fail if the cluster has none available.
+type ReadWritePool struct {
+	primary     *Pool
+	readReplica *Pool
+}
+
+// NewReadWritePool creates a ReadWritePool. See ParseConfig for information on connString format, its load_balance
+// setting is replaced by the one of each pool.
+func NewReadWritePool(ctx context.Context, connString string) (*ReadWritePool, error) {
+	config, err := ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReadWritePoolWithConfig(ctx, config)
+}
+
+// NewReadWritePoolWithConfig creates a ReadWritePool. config must have been created by ParseConfig, each pool is
+// created with a copy of it.
+func NewReadWritePoolWithConfig(ctx context.Context, config *Config) (*ReadWritePool, error) {
+	primaryConfig := config.Copy()
+	if err := primaryConfig.ConnConfig.SetLoadBalance("only-primary"); err != nil {
+		return nil, err
+	}
+	readReplicaConfig := config.Copy()
+	if err := readReplicaConfig.ConnConfig.SetLoadBalance("only-rr"); err != nil {
+		return nil, err
+	}
+
+	primary, err := NewWithConfig(ctx, primaryConfig)
+	if err != nil {
+		return nil, err
+	}
+	readReplica, err := NewWithConfig(ctx, readReplicaConfig)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+	return &ReadWritePool{primary: primary, readReplica: readReplica}, nil
+}
+
+// Primary returns the pool of connections to the primary servers.
+func (p *ReadWritePool) Primary() *Pool { return p.primary }
+
+// ReadReplica returns the pool of connections to the read replicas.
+func (p *ReadWritePool) ReadReplica() *Pool { return p.readReplica }
+
+// Close closes both pools, see Pool.Close.
+func (p *ReadWritePool) Close() {
+	p.primary.Close()
+	p.readReplica.Close()
+}
+
+// route returns the pool queries with ctx run on.
+func (p *ReadWritePool) route(ctx context.Context) *Pool {
+	if isReadOnly(ctx) {
+		return p.readReplica
+	}
+	return p.primary
+}
+
+// Exec runs sql on a read replica if ctx was given by ReadOnly, on a primary otherwise. See Pool.Exec.
+func (p *ReadWritePool) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	return p.route(ctx).Exec(ctx, sql, arguments...)
+}
+
+// Query runs sql on a read replica if ctx was given by ReadOnly, on a primary otherwise. See Pool.Query.
+func (p *ReadWritePool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return p.route(ctx).Query(ctx, sql, args...)
+}
+
+// QueryRow runs sql on a read replica if ctx was given by ReadOnly, on a primary otherwise. See Pool.QueryRow.
+func (p *ReadWritePool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return p.route(ctx).QueryRow(ctx, sql, args...)
+}
+
+// Begin starts a transaction on a read replica if ctx was given by ReadOnly, on a primary otherwise. See Pool.Begin.
+func (p *ReadWritePool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return p.BeginTx(ctx, pgx.TxOptions{})
+}
+
+// BeginTx starts a transaction on a read replica if its access mode is pgx.ReadOnly or ctx was given by ReadOnly, on a
+// primary otherwise. See Pool.BeginTx.
+func (p *ReadWritePool) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	if txOptions.AccessMode == pgx.ReadOnly {
+		return p.readReplica.BeginTx(ctx, txOptions)
+	}
+	return p.route(ctx).BeginTx(ctx, txOptions)
+}
@@ -8,10 +8,11 @@ import (
 
 // Stat is a snapshot of Pool statistics.
 type Stat struct {
-	s                    *puddle.Stat
-	newConnsCount        int64
-	lifetimeDestroyCount int64
-	idleDestroyCount     int64
+	s                     *puddle.Stat
+	newConnsCount         int64
+	lifetimeDestroyCount  int64
+	idleDestroyCount      int64
+	rebalanceDestroyCount int64
 }
 
 // AcquireCount returns the cumulative count of successful acquires from the pool.
@@ -82,3 +83,9 @@ func (s *Stat) MaxLifetimeDestroyCount() int64 {
 func (s *Stat) MaxIdleDestroyCount() int64 {
 	return s.idleDestroyCount
 }
+
+// RebalanceDestroyCount returns the cumulative count of idle connections destroyed because the load balancer would no
+// longer select their server, see Config.RebalanceInterval.
+func (s *Stat) RebalanceDestroyCount() int64 {
+	return s.rebalanceDestroyCount
+}
@@ -0,0 +1,119 @@
+package stdlib_test
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/internal/ybmock"
+	"github.com/yugabyte/pgx/v5/stdlib"
+)
+
+// hostConnections returns the connection counts of the servers of the cluster named clusterName in state.
+func hostConnections(state pgx.LoadBalancerState, clusterName string) map[string]int {
+	counts := make(map[string]int)
+	for _, cluster := range state.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		for _, h := range cluster.Hosts {
+			counts[h.Host] = h.Connections
+		}
+	}
+	return counts
+}
+
+// connectedHosts checks out n connections of db and returns the address of the server each one is connected to. The
+// connections are returned to db before connectedHosts returns.
+func connectedHosts(t *testing.T, db *sql.DB, n int) []string {
+	hosts := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		err = conn.Raw(func(driverConn any) error {
+			pgConn := driverConn.(*stdlib.Conn).Conn().PgConn()
+			hosts = append(hosts, pgConn.Conn().RemoteAddr().(*net.TCPAddr).IP.String())
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	return hosts
+}
+
+func TestLoadBalancedSQLOpen(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b", "aws.us-east-1.us-east-1c")
+
+	db, err := sql.Open("pgx", cluster.ConnString(""))
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxIdleConns(3)
+
+	hosts := connectedHosts(t, db, 3)
+	assert.ElementsMatch(t, cluster.Hosts(), hosts)
+
+	clusterName := cluster.Nodes[0].Host
+	for _, host := range cluster.Hosts() {
+		assert.Equal(t, 1, hostConnections(pgx.DumpLoadBalancerState(), clusterName)[host], host)
+	}
+
+	require.NoError(t, db.Close())
+	for _, host := range cluster.Hosts() {
+		assert.Equal(t, 0, hostConnections(pgx.DumpLoadBalancerState(), clusterName)[host], host)
+	}
+}
+
+func TestLoadBalancedRegisterConnConfig(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b")
+	preferred := cluster.Nodes[1]
+
+	m := pgx.NewClusterManager()
+	defer m.Shutdown()
+
+	config, err := pgx.ParseConfig(cluster.ConnString("topology_keys=aws.us-east-1.us-east-1b"))
+	require.NoError(t, err)
+	config.ClusterManager = m
+
+	connStr := stdlib.RegisterConnConfig(config)
+	defer stdlib.UnregisterConnConfig(connStr)
+	db, err := sql.Open("pgx", connStr)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxIdleConns(3)
+
+	for _, host := range connectedHosts(t, db, 3) {
+		assert.Equal(t, preferred.Host, host)
+	}
+	assert.Equal(t, 3, hostConnections(m.State(), cluster.Nodes[0].Host)[preferred.Host])
+
+	require.NoError(t, db.Close())
+	assert.Equal(t, 0, hostConnections(m.State(), cluster.Nodes[0].Host)[preferred.Host])
+}
+
+func TestLoadBalancedOpenDB(t *testing.T) {
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b")
+	preferred := cluster.Nodes[0]
+
+	m := pgx.NewClusterManager()
+	defer m.Shutdown()
+
+	config, err := pgx.ParseConfig(cluster.ConnString("topology_keys=aws.us-east-1.us-east-1a"))
+	require.NoError(t, err)
+	config.ClusterManager = m
+
+	db := stdlib.OpenDB(*config)
+	defer db.Close()
+
+	for _, host := range connectedHosts(t, db, 2) {
+		assert.Equal(t, preferred.Host, host)
+	}
+	assert.Equal(t, 2, hostConnections(m.State(), preferred.Host)[preferred.Host])
+
+	require.NoError(t, db.Close())
+	assert.Equal(t, 0, hostConnections(m.State(), preferred.Host)[preferred.Host])
+}
@@ -32,6 +32,13 @@
 //	connStr := stdlib.RegisterConnConfig(connConfig)
 //	db, _ := sql.Open("pgx", connStr)
 //
+// Connections are load balanced across the servers of a YugabyteDB cluster as they are by pgx.Connect when the
+// connection string or pgx.ConnConfig sets load_balance, honoring topology_keys and the other load balancing
+// parameters. A connection closed by database/sql, including one discarded by the pool, is no longer counted against
+// its server.
+//
+//	db, err := sql.Open("pgx", "postgres://yugabyte@localhost:5433/yugabyte?load_balance=true&topology_keys=cloud1.region1.zone1")
+//
 // pgx uses standard PostgreSQL positional parameters in queries. e.g. $1, $2. It does not support named parameters.
 //
 //	db.QueryRow("select * from users where id=$1", userID)
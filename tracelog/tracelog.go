@@ -117,8 +117,8 @@ func logQueryArgs(args []any) []any {
 	return logArgs
 }
 
-// TraceLog implements pgx.QueryTracer, pgx.BatchTracer, pgx.ConnectTracer, and pgx.CopyFromTracer. All fields are
-// required.
+// TraceLog implements pgx.QueryTracer, pgx.BatchTracer, pgx.ConnectTracer, pgx.CopyFromTracer, pgx.LBConnectTracer,
+// pgx.LBRefreshTracer and pgx.LBHostSelectTracer. All fields are required.
 type TraceLog struct {
 	Logger   Logger
 	LogLevel LogLevel
@@ -133,6 +133,9 @@ const (
 	tracelogCopyFromCtxKey
 	tracelogConnectCtxKey
 	tracelogPrepareCtxKey
+	tracelogLBConnectCtxKey
+	tracelogLBRefreshCtxKey
+	tracelogLBHostSelectCtxKey
 )
 
 type traceQueryData struct {
@@ -315,6 +318,143 @@ func (tl *TraceLog) TracePrepareEnd(ctx context.Context, conn *pgx.Conn, data pg
 	}
 }
 
+type traceLBConnectData struct {
+	startTime  time.Time
+	connConfig *pgx.ConnConfig
+}
+
+func (tl *TraceLog) TraceLBConnectStart(ctx context.Context, data pgx.TraceLBConnectStartData) context.Context {
+	return context.WithValue(ctx, tracelogLBConnectCtxKey, &traceLBConnectData{
+		startTime:  time.Now(),
+		connConfig: data.ConnConfig,
+	})
+}
+
+func (tl *TraceLog) TraceLBConnectEnd(ctx context.Context, data pgx.TraceLBConnectEndData) {
+	connectData := ctx.Value(tracelogLBConnectCtxKey).(*traceLBConnectData)
+
+	endTime := time.Now()
+	interval := endTime.Sub(connectData.startTime)
+
+	if data.Err != nil {
+		if tl.shouldLog(LogLevelError) {
+			tl.Logger.Log(ctx, LogLevelError, "LBConnect", map[string]any{
+				"cluster":  connectData.connConfig.Host,
+				"attempts": data.Attempts,
+				"time":     interval,
+				"err":      data.Err,
+			})
+		}
+		return
+	}
+
+	if data.Conn != nil {
+		if tl.shouldLog(LogLevelInfo) {
+			config := data.Conn.Config()
+			tl.log(ctx, data.Conn, LogLevelInfo, "LBConnect", map[string]any{
+				"cluster":  connectData.connConfig.Host,
+				"host":     config.Host,
+				"port":     config.Port,
+				"attempts": data.Attempts,
+				"time":     interval,
+			})
+		}
+	}
+}
+
+type traceLBRefreshData struct {
+	startTime   time.Time
+	clusterName string
+}
+
+func (tl *TraceLog) TraceLBRefreshStart(ctx context.Context, data pgx.TraceLBRefreshStartData) context.Context {
+	return context.WithValue(ctx, tracelogLBRefreshCtxKey, &traceLBRefreshData{
+		startTime:   time.Now(),
+		clusterName: data.ClusterName,
+	})
+}
+
+func (tl *TraceLog) TraceLBRefreshEnd(ctx context.Context, data pgx.TraceLBRefreshEndData) {
+	refreshData := ctx.Value(tracelogLBRefreshCtxKey).(*traceLBRefreshData)
+
+	endTime := time.Now()
+	interval := endTime.Sub(refreshData.startTime)
+
+	if data.Err != nil {
+		if tl.shouldLog(LogLevelError) {
+			tl.Logger.Log(ctx, LogLevelError, "LBRefresh", map[string]any{
+				"cluster":     refreshData.clusterName,
+				"controlHost": data.ControlHost,
+				"time":        interval,
+				"err":         data.Err,
+			})
+		}
+		return
+	}
+
+	if tl.shouldLog(LogLevelDebug) {
+		tl.Logger.Log(ctx, LogLevelDebug, "LBRefresh", map[string]any{
+			"cluster":     refreshData.clusterName,
+			"controlHost": data.ControlHost,
+			"servers":     data.Servers,
+			"time":        interval,
+		})
+	}
+}
+
+type traceLBHostSelectData struct {
+	startTime   time.Time
+	clusterName string
+}
+
+func (tl *TraceLog) TraceLBHostSelectStart(ctx context.Context, data pgx.TraceLBHostSelectStartData) context.Context {
+	return context.WithValue(ctx, tracelogLBHostSelectCtxKey, &traceLBHostSelectData{
+		startTime:   time.Now(),
+		clusterName: data.ClusterName,
+	})
+}
+
+func (tl *TraceLog) TraceLBHostSelectEnd(ctx context.Context, data pgx.TraceLBHostSelectEndData) {
+	selectData := ctx.Value(tracelogLBHostSelectCtxKey).(*traceLBHostSelectData)
+
+	endTime := time.Now()
+	interval := endTime.Sub(selectData.startTime)
+
+	if data.Err != nil {
+		if tl.shouldLog(LogLevelError) {
+			tl.Logger.Log(ctx, LogLevelError, "LBHostSelect", map[string]any{
+				"cluster":    selectData.clusterName,
+				"candidates": data.Candidates,
+				"time":       interval,
+				"err":        data.Err,
+			})
+		}
+		return
+	}
+
+	if tl.shouldLog(LogLevelDebug) {
+		tl.Logger.Log(ctx, LogLevelDebug, "LBHostSelect", map[string]any{
+			"cluster":      selectData.clusterName,
+			"host":         data.Host,
+			"port":         data.Port,
+			"nodeType":     data.NodeType,
+			"topologyTier": data.TopologyTier,
+			"candidates":   data.Candidates,
+			"time":         interval,
+		})
+	}
+}
+
+// LBLogger returns a pgx.LBLogger logging the diagnostics of the load balancer to tl.Logger, up to tl.LogLevel. Set it
+// as pgx.ConnConfig.LBLogger, or with pgx.SetDefaultLBLogger, to log them along with the traces of tl.
+func (tl *TraceLog) LBLogger() pgx.LBLogger {
+	return pgx.LBLoggerFunc(func(level pgx.LBLogLevel, msg string) {
+		if tl.shouldLog(LogLevel(level)) {
+			tl.Logger.Log(context.Background(), LogLevel(level), msg, map[string]any{})
+		}
+	})
+}
+
 func (tl *TraceLog) shouldLog(lvl LogLevel) bool {
 	return tl.LogLevel >= lvl
 }
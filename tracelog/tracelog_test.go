@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/internal/ybmock"
 	"github.com/yugabyte/pgx/v5/pgxtest"
 	"github.com/yugabyte/pgx/v5/tracelog"
 )
@@ -457,3 +458,73 @@ func TestLogPrepare(t *testing.T) {
 		require.Equal(t, err, logger.logs[0].data["err"])
 	})
 }
+
+func TestLogLoadBalancedConnect(t *testing.T) {
+	t.Parallel()
+
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a", "aws.us-east-1.us-east-1b")
+	m := pgx.NewClusterManager()
+	defer m.Shutdown()
+
+	logger := &testLogger{}
+	config, err := pgx.ParseConfig(cluster.ConnString("topology_keys=aws.us-east-1.us-east-1b"))
+	require.NoError(t, err)
+	config.ClusterManager = m
+	config.Tracer = &tracelog.TraceLog{
+		Logger:   logger,
+		LogLevel: tracelog.LogLevelTrace,
+	}
+
+	conn, err := pgx.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	logs := logger.FilterByMsg("LBRefresh")
+	require.Len(t, logs, 1)
+	require.Equal(t, tracelog.LogLevelDebug, logs[0].lvl)
+	require.Equal(t, cluster.Hosts(), logs[0].data["servers"])
+
+	logs = logger.FilterByMsg("LBHostSelect")
+	require.Len(t, logs, 1)
+	require.Equal(t, tracelog.LogLevelDebug, logs[0].lvl)
+	require.Equal(t, cluster.Nodes[1].Host, logs[0].data["host"])
+	require.Equal(t, []string{cluster.Nodes[1].Host}, logs[0].data["candidates"])
+
+	logs = logger.FilterByMsg("LBConnect")
+	require.Len(t, logs, 1)
+	require.Equal(t, tracelog.LogLevelInfo, logs[0].lvl)
+	require.Equal(t, cluster.Nodes[1].Host, logs[0].data["host"])
+	require.Equal(t, 1, logs[0].data["attempts"])
+}
+
+func TestLBLogger(t *testing.T) {
+	t.Parallel()
+
+	cluster := ybmock.NewCluster(t, "aws.us-east-1.us-east-1a")
+	cluster.AddNode("read_replica", "aws.us-east-1.us-east-1b")
+	connString := strings.Replace(cluster.ConnString("topology_keys=aws.us-east-1.us-east-1a"), "load_balance=true",
+		"load_balance=only-rr", 1)
+	warning := "Topology key aws.us-east-1.us-east-1a can never match with load_balance=only-rr, it only has primary servers"
+
+	for _, level := range []tracelog.LogLevel{tracelog.LogLevelWarn, tracelog.LogLevelError} {
+		m := pgx.NewClusterManager()
+		defer m.Shutdown()
+		logger := &testLogger{}
+		config, err := pgx.ParseConfig(connString)
+		require.NoError(t, err)
+		config.ClusterManager = m
+		config.LBLogger = (&tracelog.TraceLog{Logger: logger, LogLevel: level}).LBLogger()
+
+		conn, err := pgx.ConnectConfig(context.Background(), config)
+		require.NoError(t, err)
+		defer conn.Close(context.Background())
+
+		logs := logger.FilterByMsg(warning)
+		if level == tracelog.LogLevelWarn {
+			require.Len(t, logs, 1)
+			require.Equal(t, tracelog.LogLevelWarn, logs[0].lvl)
+		} else {
+			require.Empty(t, logs)
+		}
+	}
+}
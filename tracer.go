@@ -105,3 +105,77 @@ type TraceConnectEndData struct {
 	Conn *Conn
 	Err  error
 }
+
+// LBConnectTracer traces load balanced Connect and ConnectConfig calls, i.e. the selection of a server followed by the
+// connection attempts to it.
+type LBConnectTracer interface {
+	// TraceLBConnectStart is called at the beginning of a load balanced connect. The returned context is used for the
+	// rest of the call and will be passed to TraceLBConnectEnd.
+	TraceLBConnectStart(ctx context.Context, data TraceLBConnectStartData) context.Context
+
+	TraceLBConnectEnd(ctx context.Context, data TraceLBConnectEndData)
+}
+
+type TraceLBConnectStartData struct {
+	ConnConfig *ConnConfig
+	// ClusterName is the name the load information of the cluster is kept under, as returned by ConnConfig.ClusterName.
+	ClusterName string
+}
+
+type TraceLBConnectEndData struct {
+	Conn *Conn
+	// Attempts is the number of servers a connection was attempted to.
+	Attempts int
+	Err      error
+}
+
+// LBRefreshTracer traces the refreshes of the load information of a cluster.
+type LBRefreshTracer interface {
+	// TraceLBRefreshStart is called at the beginning of a refresh. The returned context will be passed to
+	// TraceLBRefreshEnd.
+	TraceLBRefreshStart(ctx context.Context, data TraceLBRefreshStartData) context.Context
+
+	TraceLBRefreshEnd(ctx context.Context, data TraceLBRefreshEndData)
+}
+
+type TraceLBRefreshStartData struct {
+	ClusterName string
+}
+
+type TraceLBRefreshEndData struct {
+	ControlHost string
+	// Servers are the hosts of the servers of the cluster listed by the refresh, in lexical order.
+	Servers []string
+	Err     error
+}
+
+// LBHostSelectTracer traces the selection of the least loaded server of a cluster.
+type LBHostSelectTracer interface {
+	// TraceLBHostSelectStart is called at the beginning of a selection. The returned context will be passed to
+	// TraceLBHostSelectEnd.
+	TraceLBHostSelectStart(ctx context.Context, data TraceLBHostSelectStartData) context.Context
+
+	TraceLBHostSelectEnd(ctx context.Context, data TraceLBHostSelectEndData)
+}
+
+type TraceLBHostSelectStartData struct {
+	ClusterName string
+}
+
+type TraceLBHostSelectEndData struct {
+	Host     string
+	Port     uint16
+	NodeType string
+	// TopologyTier is the preference value of the topology_keys the host was selected by minus one, so 0 for the keys
+	// without a preference value, -1 if it was not selected by topology_keys.
+	TopologyTier int
+	// TopologyFallback is true if topology_keys are set but the host was selected from the rest of the cluster because
+	// no server matching them was available.
+	TopologyFallback bool
+	// FallbackLevel is the level of the fallback ladder the host was selected from if TopologyFallback is true.
+	FallbackLevel FallbackLevel
+	// Candidates are the eligible servers the host was selected from, in lexical order. They are nil if the selection
+	// failed before the eligible servers were known.
+	Candidates []string
+	Err        error
+}